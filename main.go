@@ -2,20 +2,32 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 	"unicode"
 
@@ -28,17 +40,61 @@ const (
 	defaultTransientRetries  = 1
 	eventScannerMaxTokenSize = 8 * 1024 * 1024
 	basePort                 = 4096
-	ocCleanupPortScanCount   = 256
+	agentPIDFile             = "agent.pid"
+	agentStopGracePeriod     = 5 * time.Second
+	serverReadyTimeout       = 15 * time.Second
+	serverStartAttempts      = 3
 	promptsFile              = "prompts.json"
 	savedModelsFile          = "saved-models.json"
+	gradersFile              = "graders.json"
+	defaultGraderTimeout     = 300 * time.Second
+	graderOutputLimit        = 64 * 1024
+	promptTemplateFile       = "prompt.template.txt"
+	outputText               = "text"
+	outputJSON               = "json"
+	outputJSONL              = "jsonl"
+	defaultAgentBackend      = "opencode"
+	runsDir                  = "runs"
+	defaultEvalModel         = "opencode/kimi-k2.5-free"
+	attemptsDir              = "attempts"
+	// workspaceArchiveSuffix names the sibling of an eval folder that
+	// resume --fresh archives old workspaces into, out of the agent's reach.
+	workspaceArchiveSuffix = ".attempts"
+)
+
+// version is set for releases with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// Exit codes for run, resume and diff.
+const (
+	exitOK              = 0
+	exitSetupError      = 1
+	exitEvalsFailed     = 2
+	exitThresholdNotMet = 3
+	exitRegression      = 4
+	// exitInterrupted follows the shell convention of 128 + SIGINT.
+	exitInterrupted = 130
 )
 
 var (
 	inactivityTimeout = defaultInactivityTimeout
 	transientRetries  = defaultTransientRetries
-	promptNumberRE    = regexp.MustCompile(`(?:^|_)p(\d+)(?:_|$)`)
+	// maxEvalDuration and maxEvalCost cap every eval; zero means no limit.
+	maxEvalDuration  time.Duration
+	maxEvalCost      float64
+	promptNumberRE   = regexp.MustCompile(`(?:^|_)p(\d+)(?:_|$)`)
+	placeholderRE    = regexp.MustCompile(`<([A-Z][A-Z0-9_]*)>`)
+	promptVars       = map[string]string{}
+	outputMode       = outputText
+	agentBackendName = defaultAgentBackend
+	// interactiveSession is set when evals were picked with forms; only then
+	// is a model-not-found error answered with a correction prompt.
+	interactiveSession bool
 )
 
+// builtinPromptVarNames are filled in per eval and cannot be set with --var.
+var builtinPromptVarNames = []string{"MODEL_NAME", "MODEL_ID", "PROMPT_NUMBER", "EVAL_FOLDER", "TIMESTAMP"}
+
 func newEscBackForm(groups ...*huh.Group) *huh.Form {
 	keymap := huh.NewDefaultKeyMap()
 	keymap.Quit = key.NewBinding(
@@ -59,21 +115,169 @@ func runFormWithBack(form *huh.Form) (aborted bool, err error) {
 	return false, err
 }
 
+// FailureCategory says why an eval failed. It is empty for evals that passed.
+type FailureCategory string
+
+const (
+	failureSetup         FailureCategory = "setup"
+	failureServerStart   FailureCategory = "server_start"
+	failureSessionCreate FailureCategory = "session_create"
+	failureSendPrompt    FailureCategory = "send_prompt"
+	failureStream        FailureCategory = "stream"
+	failureInactivity    FailureCategory = "inactivity"
+	failureSessionError  FailureCategory = "session_error"
+	failureModelNotFound FailureCategory = "model_not_found"
+	failureBudget        FailureCategory = "budget_exceeded"
+	failureGrader        FailureCategory = "grader"
+	failureInterrupted   FailureCategory = "interrupted"
+)
+
+var failureCategories = []FailureCategory{
+	failureSetup, failureServerStart, failureSessionCreate, failureSendPrompt, failureStream,
+	failureInactivity, failureSessionError, failureModelNotFound, failureBudget, failureGrader, failureInterrupted,
+}
+
+// transient reports whether a failure is worth retrying within the same run.
+func (c FailureCategory) transient() bool {
+	return c == failureInactivity || c == failureStream
+}
+
+func (c FailureCategory) known() bool {
+	for _, known := range failureCategories {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFailureCategories(raw string) ([]FailureCategory, error) {
+	var categories []FailureCategory
+	for _, s := range strings.Split(raw, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		c := FailureCategory(s)
+		if !c.known() {
+			names := make([]string, len(failureCategories))
+			for i, known := range failureCategories {
+				names[i] = string(known)
+			}
+			return nil, fmt.Errorf("unknown failure category %q (use %s)", s, strings.Join(names, ", "))
+		}
+		categories = append(categories, c)
+	}
+	return categories, nil
+}
+
+// legacyFailureCategory classifies a failed result.json written before
+// failure categories existed from its error text.
+func legacyFailureCategory(rf EvalResultFile) FailureCategory {
+	if rf.Success {
+		return ""
+	}
+	msg := rf.Error
+	switch {
+	case msg == "interrupted", msg == "not started: run interrupted":
+		return failureInterrupted
+	case strings.HasPrefix(msg, "budget exceeded"):
+		return failureBudget
+	case strings.HasPrefix(msg, "grader failed"):
+		return failureGrader
+	case strings.HasPrefix(msg, "no agent activity for"):
+		return failureInactivity
+	case strings.HasPrefix(msg, "event stream error"), strings.HasPrefix(msg, "Failed to subscribe to events"),
+		msg == "agent did not reach idle state":
+		return failureStream
+	case strings.Contains(msg, "Model not found"):
+		return failureModelNotFound
+	case strings.HasPrefix(msg, "Failed to send prompt"):
+		return failureSendPrompt
+	case strings.HasPrefix(msg, "Server not ready"), strings.HasPrefix(msg, "Server on port"):
+		return failureSessionCreate
+	case strings.HasPrefix(msg, "Failed to start"), strings.HasPrefix(msg, "Could not allocate a free port"):
+		return failureServerStart
+	case strings.HasPrefix(msg, "Failed to render prompt"), strings.HasPrefix(msg, "Failed to setup folder"),
+		strings.HasPrefix(msg, "unknown agent backend"):
+		return failureSetup
+	case msg != "":
+		return failureSessionError
+	}
+	return failureStream
+}
+
 type EvalResult struct {
-	Prompt       string
-	PromptNumber int
-	Folder       string
-	Success      bool
-	Error        string
-	Duration     time.Duration
+	Prompt         string
+	PromptNumber   int
+	Model          string
+	Folder         string
+	Success        bool
+	AgentCompleted bool
+	GradedPass     *bool
+	GraderStdout   string
+	GraderStderr   string
+	Error          string
+	Duration       time.Duration
+	CostUSD        float64
+	Tokens         TokenUsage
+	Attempts       int
+	Trial          int
+	Suite          *SuiteRef
+	RunID          string
+	Port           int
+	Failure        FailureCategory
 }
 
-type PromptJSON []string
+type TokenUsage struct {
+	Input      int `json:"input"`
+	Output     int `json:"output"`
+	Reasoning  int `json:"reasoning"`
+	CacheRead  int `json:"cache_read"`
+	CacheWrite int `json:"cache_write"`
+}
+
+func (u TokenUsage) add(other TokenUsage) TokenUsage {
+	return TokenUsage{
+		Input:      u.Input + other.Input,
+		Output:     u.Output + other.Output,
+		Reasoning:  u.Reasoning + other.Reasoning,
+		CacheRead:  u.CacheRead + other.CacheRead,
+		CacheWrite: u.CacheWrite + other.CacheWrite,
+	}
+}
+
+func (u TokenUsage) isZero() bool {
+	return u == TokenUsage{}
+}
+
+const promptSchemaVersion = 3
+
+// PromptFile is the on-disk format of prompts.json. Prompt IDs are assigned
+// from NextID and never reused, so p<ID> folder names stay valid after edits
+// and removals.
+type PromptFile struct {
+	Version int            `json:"version"`
+	NextID  int            `json:"next_id"`
+	Prompts []PromptRecord `json:"prompts"`
+}
+
+type PromptRecord struct {
+	ID             int      `json:"id"`
+	Title          string   `json:"title,omitempty"`
+	Text           string   `json:"text"`
+	Tags           []string `json:"tags,omitempty"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"`
+	Grader         *Grader  `json:"grader,omitempty"`
+	// Vars are the user variables the prompt needs. Other upper-case text in
+	// angle brackets, such as <HTML>, is sent as written.
+	Vars []string `json:"vars,omitempty"`
+}
 
 type Session struct {
-	ID    string `json:"id"`
-	Title string `json:"title"`
-	Slug  string `json:"slug"`
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Slug      string `json:"slug"`
+	Directory string `json:"directory,omitempty"`
 }
 
 type Event struct {
@@ -97,14 +301,26 @@ type PromptRequest struct {
 }
 
 type EvalResultFile struct {
-	Prompt          string  `json:"prompt"`
-	PromptNumber    int     `json:"prompt_number,omitempty"`
-	Model           string  `json:"model"`
-	Success         bool    `json:"success"`
-	Error           string  `json:"error,omitempty"`
-	DurationSeconds int     `json:"duration_seconds"`
-	CompletedAt     string  `json:"completed_at"`
-	CostUSD         float64 `json:"cost_usd,omitempty"`
+	Prompt          string          `json:"prompt"`
+	PromptNumber    int             `json:"prompt_number,omitempty"`
+	Model           string          `json:"model"`
+	Success         bool            `json:"success"`
+	AgentCompleted  bool            `json:"agent_completed"`
+	GradedPass      *bool           `json:"graded_pass,omitempty"`
+	GraderStdout    string          `json:"grader_stdout,omitempty"`
+	GraderStderr    string          `json:"grader_stderr,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	DurationSeconds int             `json:"duration_seconds"`
+	CompletedAt     string          `json:"completed_at"`
+	CostUSD         float64         `json:"cost_usd,omitempty"`
+	Tokens          *TokenUsage     `json:"tokens,omitempty"`
+	Attempts        int             `json:"attempts,omitempty"`
+	Trial           int             `json:"trial,omitempty"`
+	Suite           *SuiteRef       `json:"suite,omitempty"`
+	RunID           string          `json:"run_id,omitempty"`
+	Failure         FailureCategory `json:"failure,omitempty"`
+	Port            int             `json:"port,omitempty"`
+	History         *AttemptHistory `json:"history,omitempty"`
 }
 
 type EvalFolder struct {
@@ -112,6 +328,9 @@ type EvalFolder struct {
 	Prompt       string
 	PromptNumber int
 	Result       *EvalResultFile
+	// Missing marks a task of a run that left no result; Result then holds
+	// a failure so diff does not mistake it for a removed eval.
+	Missing bool
 }
 
 type Provider struct {
@@ -131,12 +350,6 @@ type ProvidersResponse struct {
 	Default   map[string]string `json:"default"`
 }
 
-type listeningProcess struct {
-	Command string
-	PID     int
-	Port    int
-}
-
 func main() {
 	if len(os.Args) < 2 {
 		interactiveMenu()
@@ -147,9 +360,13 @@ func main() {
 
 	switch command {
 	case "run":
-		runCommand()
+		os.Exit(runCommand())
 	case "resume":
-		resumeCommand()
+		os.Exit(resumeCommand())
+	case "report":
+		os.Exit(reportCommand(os.Args[2:]))
+	case "diff":
+		os.Exit(diffCommand(os.Args[2:]))
 	case "models":
 		modelsCommand(os.Args[2:])
 	case "oc":
@@ -177,7 +394,7 @@ func interactiveMenu() {
 
 		promptCount := 0
 		if prompts, err := loadPrompts(); err == nil {
-			promptCount = len(prompts)
+			promptCount = len(prompts.Prompts)
 		}
 
 		evalCount := 0
@@ -257,7 +474,9 @@ Usage:
 Commands:
   run      Interactively select prompts and model, then run evals
   resume   Resume or re-run previous evals from the evals/ folder
-  oc       OpenCode utilities (cleanup stale local sessions)
+  report   Compare models over evals/ (--format text|markdown|csv|json)
+  diff     Compare two runs and exit 4 on regressions
+  oc       OpenCode utilities (cleanup servers left running by earlier evals)
   models   Interactively browse and save models for reuse
   list     List all prompts in prompts.json
   add      Add a new prompt to prompts.json
@@ -268,6 +487,8 @@ Commands:
 Examples:
   high-evals run
   high-evals resume
+  high-evals report --format markdown
+  high-evals diff 20260213-201542-3f9a1c 20260214-091003-77b2e0
   high-evals oc cleanup
   high-evals models
   high-evals models list
@@ -297,160 +518,95 @@ func ocCommand(args []string) {
 	}
 }
 
+// ocCleanupCommand stops agent servers left behind by earlier runs, found
+// through the PID files that runAgent writes into each eval folder.
 func ocCleanupCommand() {
-	minPort := basePort
-	maxPort := basePort + ocCleanupPortScanCount - 1
-
-	procs, err := listListeningOpencodeProcesses(minPort, maxPort)
+	pidFiles, err := filepath.Glob(filepath.Join("evals", "*", agentPIDFile))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error scanning local opencode sessions: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error scanning eval folders: %v\n", err)
 		os.Exit(1)
 	}
 
-	if len(procs) == 0 {
-		fmt.Printf("No stale opencode sessions found on ports %d-%d.\n", minPort, maxPort)
-		return
-	}
-
-	portsByPID := make(map[int][]int)
-	commandByPID := make(map[int]string)
-	for _, p := range procs {
-		portsByPID[p.PID] = append(portsByPID[p.PID], p.Port)
-		commandByPID[p.PID] = p.Command
-	}
-
-	pids := make([]int, 0, len(portsByPID))
-	for pid := range portsByPID {
-		pids = append(pids, pid)
-	}
-	sort.Ints(pids)
-
-	fmt.Printf("Found %d opencode session process(es) to clean up.\n", len(pids))
-
-	cleaned := 0
-	failed := 0
-	for _, pid := range pids {
-		ports := portsByPID[pid]
-		sort.Ints(ports)
-		if err := terminateProcess(pid, ports); err != nil {
-			fmt.Printf("✗ PID %d (%s) on ports %s: %v\n", pid, commandByPID[pid], formatPorts(ports), err)
+	cleaned, failed, stale := 0, 0, 0
+	for _, path := range pidFiles {
+		pid, err := readAgentPIDFile(path)
+		if err != nil {
+			fmt.Printf("✗ %s: %v\n", path, err)
 			failed++
 			continue
 		}
-		fmt.Printf("✓ Stopped PID %d (%s) on ports %s\n", pid, commandByPID[pid], formatPorts(ports))
-		cleaned++
-	}
-
-	fmt.Printf("Cleanup complete: %d stopped, %d failed.\n", cleaned, failed)
-	if failed > 0 {
-		os.Exit(1)
-	}
-}
-
-func listListeningOpencodeProcesses(minPort, maxPort int) ([]listeningProcess, error) {
-	output, err := exec.Command("lsof", "-nP", "-iTCP", "-sTCP:LISTEN").CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("running lsof: %w (%s)", err, strings.TrimSpace(string(output)))
-	}
-
-	lineRE := regexp.MustCompile(`^(\S+)\s+(\d+)\s+.*TCP .*:(\d+) \(LISTEN\)$`)
-	lines := strings.Split(string(output), "\n")
-	procs := make([]listeningProcess, 0)
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "COMMAND ") {
-			continue
-		}
-
-		m := lineRE.FindStringSubmatch(line)
-		if len(m) != 4 {
-			continue
-		}
+		folder := filepath.Dir(path)
 
-		command := strings.ToLower(m[1])
-		if !strings.Contains(command, "opencode") {
+		if !processGroupAlive(pid) {
+			os.Remove(path)
+			stale++
 			continue
 		}
-
-		pid, err := strconv.Atoi(m[2])
-		if err != nil || pid <= 0 {
+		if command, err := getProcessCommand(pid); err == nil && !strings.Contains(strings.ToLower(command), "opencode") {
+			// The PID was reused by an unrelated process after the server exited.
+			os.Remove(path)
+			stale++
 			continue
 		}
 
-		port, err := strconv.Atoi(m[3])
-		if err != nil {
-			continue
-		}
-		if port < minPort || port > maxPort {
+		if err := terminateProcessGroup(pid); err != nil {
+			fmt.Printf("✗ PID %d in %s: %v\n", pid, folder, err)
+			failed++
 			continue
 		}
+		os.Remove(path)
+		fmt.Printf("✓ Stopped PID %d in %s\n", pid, folder)
+		cleaned++
+	}
 
-		procs = append(procs, listeningProcess{
-			Command: m[1],
-			PID:     pid,
-			Port:    port,
-		})
+	if cleaned == 0 && failed == 0 {
+		fmt.Println("No running opencode servers found in evals/.")
+	}
+	if stale > 0 {
+		fmt.Printf("Removed %d stale PID file(s).\n", stale)
+	}
+	if cleaned > 0 || failed > 0 {
+		fmt.Printf("Cleanup complete: %d stopped, %d failed.\n", cleaned, failed)
 	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
 
-	return procs, nil
+func writeAgentPIDFile(dir string, pid int) error {
+	return os.WriteFile(filepath.Join(dir, agentPIDFile), []byte(strconv.Itoa(pid)+"\n"), 0644)
 }
 
-func terminateProcess(pid int, ports []int) error {
-	_ = terminateSinglePID(pid)
-	if waitForPortsClosed(ports, 2*time.Second) {
-		return nil
+func readAgentPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
 	}
-
-	parentPID, err := getParentPID(pid)
-	if err == nil && parentPID > 1 {
-		parentCmd, cmdErr := getProcessCommand(parentPID)
-		if cmdErr == nil && strings.Contains(strings.ToLower(parentCmd), "high-evals") {
-			_ = terminateSinglePID(parentPID)
-			if waitForPortsClosed(ports, 2*time.Second) {
-				return nil
-			}
-		}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID %q", strings.TrimSpace(string(data)))
 	}
-
-	return errors.New("session ports still listening after termination attempts")
+	return pid, nil
 }
 
-func terminateSinglePID(pid int) error {
-	pidStr := strconv.Itoa(pid)
-	_ = exec.Command("kill", "-TERM", pidStr).Run()
-
-	deadline := time.Now().Add(1200 * time.Millisecond)
+// terminateProcessGroup sends SIGTERM to the process group led by pid and
+// SIGKILL if it is still alive shortly after.
+func terminateProcessGroup(pid int) error {
+	_ = signalProcessGroup(pid, syscall.SIGTERM)
+	deadline := time.Now().Add(2 * time.Second)
 	for time.Now().Before(deadline) {
-		if !isProcessAlive(pid) {
+		if !processGroupAlive(pid) {
 			return nil
 		}
 		time.Sleep(100 * time.Millisecond)
 	}
 
-	_ = exec.Command("kill", "-KILL", pidStr).Run()
+	_ = signalProcessGroup(pid, os.Kill)
 	time.Sleep(150 * time.Millisecond)
-	return nil
-}
-
-func isProcessAlive(pid int) bool {
-	return exec.Command("kill", "-0", strconv.Itoa(pid)).Run() == nil
-}
-
-func getParentPID(pid int) (int, error) {
-	output, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "ppid=").CombinedOutput()
-	if err != nil {
-		return 0, fmt.Errorf("reading parent PID for %d: %w (%s)", pid, err, strings.TrimSpace(string(output)))
-	}
-	parentStr := strings.TrimSpace(string(output))
-	if parentStr == "" {
-		return 0, errors.New("empty parent PID")
-	}
-	parentPID, err := strconv.Atoi(parentStr)
-	if err != nil {
-		return 0, fmt.Errorf("invalid parent PID %q", parentStr)
+	if processGroupAlive(pid) {
+		return errors.New("process group still running after SIGKILL")
 	}
-	return parentPID, nil
+	return nil
 }
 
 func getProcessCommand(pid int) (string, error) {
@@ -461,43 +617,12 @@ func getProcessCommand(pid int) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
-func waitForPortsClosed(ports []int, timeout time.Duration) bool {
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		if !anyPortListening(ports) {
-			return true
-		}
-		time.Sleep(100 * time.Millisecond)
-	}
-	return !anyPortListening(ports)
-}
-
-func anyPortListening(ports []int) bool {
-	for _, port := range ports {
-		if isPortListening(port) {
-			return true
-		}
-	}
-	return false
-}
-
-func isPortListening(port int) bool {
-	output, err := exec.Command("lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN", "-t").CombinedOutput()
-	if err != nil {
-		return strings.TrimSpace(string(output)) != ""
-	}
-	return strings.TrimSpace(string(output)) != ""
-}
-
-func formatPorts(ports []int) string {
-	if len(ports) == 0 {
-		return "-"
+func joinInts(values []int, sep string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
 	}
-	parts := make([]string, len(ports))
-	for i, p := range ports {
-		parts[i] = strconv.Itoa(p)
-	}
-	return strings.Join(parts, ",")
+	return strings.Join(parts, sep)
 }
 
 func modelsCommand(args []string) {
@@ -594,33 +719,142 @@ func interactiveModelsCommand() {
 	fmt.Printf("Saved %d model(s) to %s.\n", added, savedModelsFile)
 }
 
-func loadPrompts() (PromptJSON, error) {
+// loadPrompts reads prompts.json, migrating older schemas in memory. The
+// migration is written by the next command that saves prompts, so read-only
+// commands such as report and diff never rewrite the file.
+func loadPrompts() (PromptFile, error) {
 	data, err := os.ReadFile(promptsFile)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return PromptJSON{}, nil
+			return newPromptFile(), nil
 		}
-		return nil, err
+		return PromptFile{}, err
 	}
 
-	if len(data) == 0 {
-		return PromptJSON{}, nil
+	pf, _, err := parsePromptFile(data)
+	return pf, err
+}
+
+func newPromptFile() PromptFile {
+	return PromptFile{Version: promptSchemaVersion, NextID: 1, Prompts: []PromptRecord{}}
+}
+
+// parsePromptFile decodes prompts.json, converting the legacy array of
+// strings into records numbered by their old 1-based position.
+func parsePromptFile(data []byte) (PromptFile, bool, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" {
+		return newPromptFile(), false, nil
 	}
 
-	var prompts PromptJSON
-	if err := json.Unmarshal(data, &prompts); err != nil {
-		return nil, err
+	if strings.HasPrefix(trimmed, "[") {
+		var legacy []string
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return PromptFile{}, false, err
+		}
+		pf := newPromptFile()
+		for i, text := range legacy {
+			pf.Prompts = append(pf.Prompts, PromptRecord{ID: i + 1, Text: text, Vars: detectPromptVars(text)})
+		}
+		pf.NextID = len(legacy) + 1
+		return pf, true, nil
+	}
+
+	var pf PromptFile
+	if err := json.Unmarshal(data, &pf); err != nil {
+		return PromptFile{}, false, err
+	}
+	if pf.Version > promptSchemaVersion {
+		return PromptFile{}, false, fmt.Errorf("%s has schema version %d, this build supports up to %d", promptsFile, pf.Version, promptSchemaVersion)
+	}
+	if pf.Prompts == nil {
+		pf.Prompts = []PromptRecord{}
+	}
+
+	seen := make(map[int]struct{}, len(pf.Prompts))
+	for _, p := range pf.Prompts {
+		if p.ID < 1 {
+			return PromptFile{}, false, fmt.Errorf("%s: prompt has invalid id %d", promptsFile, p.ID)
+		}
+		if _, dup := seen[p.ID]; dup {
+			return PromptFile{}, false, fmt.Errorf("%s: duplicate prompt id %d", promptsFile, p.ID)
+		}
+		seen[p.ID] = struct{}{}
+		if p.ID >= pf.NextID {
+			pf.NextID = p.ID + 1
+		}
 	}
+	migrated := pf.Version < promptSchemaVersion
+	if pf.Version < 3 {
+		// Before version 3 every placeholder had to have a value.
+		for i := range pf.Prompts {
+			pf.Prompts[i].Vars = detectPromptVars(pf.Prompts[i].Text)
+		}
+	}
+	pf.Version = promptSchemaVersion
 
-	return prompts, nil
+	return pf, migrated, nil
 }
 
-func savePrompts(prompts PromptJSON) error {
-	data, err := json.MarshalIndent(prompts, "", "  ")
-	if err != nil {
+func savePrompts(pf PromptFile) error {
+	pf.Version = promptSchemaVersion
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	// Prompts contain <PLACEHOLDERS>; keep them readable in the file.
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(pf); err != nil {
 		return err
 	}
-	return os.WriteFile(promptsFile, data, 0644)
+	return os.WriteFile(promptsFile, []byte(buf.String()), 0644)
+}
+
+func (pf *PromptFile) add(rec PromptRecord) PromptRecord {
+	if pf.NextID < 1 {
+		pf.NextID = 1
+	}
+	rec.ID = pf.NextID
+	pf.NextID++
+	pf.Prompts = append(pf.Prompts, rec)
+	return rec
+}
+
+func (pf PromptFile) find(id int) (PromptRecord, bool) {
+	for _, p := range pf.Prompts {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return PromptRecord{}, false
+}
+
+func (pf PromptFile) ids() []int {
+	ids := make([]int, len(pf.Prompts))
+	for i, p := range pf.Prompts {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+func (p PromptRecord) label(maxLen int) string {
+	text := p.Title
+	if text == "" {
+		text = p.Text
+	}
+	if len(text) > maxLen {
+		text = text[:maxLen-3] + "..."
+	}
+	return fmt.Sprintf("#%d. %s", p.ID, text)
+}
+
+func (p PromptRecord) task() EvalTask {
+	return EvalTask{
+		Prompt:            p.Text,
+		PromptNumber:      p.ID,
+		Grader:            p.Grader,
+		InactivityTimeout: time.Duration(p.TimeoutSeconds) * time.Second,
+		Vars:              p.Vars,
+	}
 }
 
 func listCommand() {
@@ -630,36 +864,110 @@ func listCommand() {
 		os.Exit(1)
 	}
 
-	if len(prompts) == 0 {
-		fmt.Println("No prompts found. Use 'high-evals add' to add one.")
+	if len(prompts.Prompts) == 0 {
+		logf("No prompts found. Use 'high-evals add' to add one.\n")
 		return
 	}
 
 	fmt.Printf("Prompts in %s:\n\n", promptsFile)
-	for i, p := range prompts {
-		preview := p
-		if len(preview) > 80 {
-			preview = preview[:77] + "..."
+	for _, p := range prompts.Prompts {
+		fmt.Printf("  %s\n", p.label(80))
+		meta := make([]string, 0, 3)
+		if len(p.Tags) > 0 {
+			meta = append(meta, "tags: "+strings.Join(p.Tags, ", "))
+		}
+		if p.TimeoutSeconds > 0 {
+			meta = append(meta, fmt.Sprintf("timeout: %ds", p.TimeoutSeconds))
+		}
+		if p.Grader != nil {
+			meta = append(meta, "graded")
+		}
+		if len(meta) > 0 {
+			fmt.Printf("      %s\n", strings.Join(meta, " · "))
 		}
-		fmt.Printf("  %d. %s\n", i+1, preview)
 	}
-	fmt.Printf("\nTotal: %d prompt(s)\n", len(prompts))
+	fmt.Printf("\nTotal: %d prompt(s)\n", len(prompts.Prompts))
 }
 
-func addCommand() {
-	var newPrompt string
+// promptRecordForm edits the user-facing fields of rec in place.
+func promptRecordForm(title string, rec *PromptRecord) (bool, error) {
+	tags := strings.Join(rec.Tags, ", ")
+	timeout := ""
+	if rec.TimeoutSeconds > 0 {
+		timeout = strconv.Itoa(rec.TimeoutSeconds)
+	}
+	graderCommand := ""
+	if rec.Grader != nil {
+		graderCommand = rec.Grader.Command
+	}
 
 	form := newEscBackForm(
 		huh.NewGroup(
 			huh.NewText().
-				Title("Enter the new prompt").
+				Title(title).
 				Description("Write a coding task for the agent to complete").
-				Value(&newPrompt).
+				Value(&rec.Text).
 				CharLimit(2000),
+			huh.NewInput().
+				Title("Title (optional)").
+				Value(&rec.Title),
+			huh.NewInput().
+				Title("Tags (optional)").
+				Description("Comma-separated").
+				Value(&tags),
+			huh.NewInput().
+				Title("Inactivity timeout in seconds (optional)").
+				Description("Overrides --inactivity-timeout for this prompt").
+				Value(&timeout).
+				Validate(func(s string) error {
+					if strings.TrimSpace(s) == "" {
+						return nil
+					}
+					if n, err := strconv.Atoi(strings.TrimSpace(s)); err != nil || n < 1 {
+						return errors.New("enter a positive number of seconds")
+					}
+					return nil
+				}),
+			huh.NewInput().
+				Title("Grader command (optional)").
+				Description("Shell command run in the eval folder; exit 0 passes").
+				Value(&graderCommand),
 		),
 	)
 
 	aborted, err := runFormWithBack(form)
+	if err != nil || aborted {
+		return aborted, err
+	}
+
+	rec.Text = strings.TrimSpace(rec.Text)
+	rec.Title = strings.TrimSpace(rec.Title)
+	rec.Tags = nil
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			rec.Tags = append(rec.Tags, tag)
+		}
+	}
+	rec.TimeoutSeconds, _ = strconv.Atoi(strings.TrimSpace(timeout))
+
+	graderCommand = strings.TrimSpace(graderCommand)
+	switch {
+	case rec.Grader != nil:
+		rec.Grader.Command = graderCommand
+		if rec.Grader.Command == "" && len(rec.Grader.RequiredFiles) == 0 && !rec.Grader.RunSmoke {
+			rec.Grader = nil
+		}
+	case graderCommand != "":
+		rec.Grader = &Grader{Command: graderCommand}
+	}
+
+	return false, nil
+}
+
+func addCommand() {
+	var rec PromptRecord
+
+	aborted, err := promptRecordForm("Enter the new prompt", &rec)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -668,8 +976,7 @@ func addCommand() {
 		return
 	}
 
-	newPrompt = strings.TrimSpace(newPrompt)
-	if newPrompt == "" {
+	if rec.Text == "" {
 		fmt.Println("Prompt cannot be empty.")
 		os.Exit(1)
 	}
@@ -680,14 +987,15 @@ func addCommand() {
 		os.Exit(1)
 	}
 
-	prompts = append(prompts, newPrompt)
+	rec.Vars = detectPromptVars(rec.Text)
+	rec = prompts.add(rec)
 
 	if err := savePrompts(prompts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error saving prompts: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Added prompt #%d\n", len(prompts))
+	fmt.Printf("Added prompt #%d\n", rec.ID)
 }
 
 func editCommand() {
@@ -697,19 +1005,15 @@ func editCommand() {
 		os.Exit(1)
 	}
 
-	if len(prompts) == 0 {
+	if len(prompts.Prompts) == 0 {
 		fmt.Println("No prompts to edit. Use 'high-evals add' to add one.")
 		return
 	}
 
 	var selectedIdx int
-	options := make([]huh.Option[int], len(prompts))
-	for i, p := range prompts {
-		preview := p
-		if len(preview) > 60 {
-			preview = preview[:57] + "..."
-		}
-		options[i] = huh.NewOption(fmt.Sprintf("%d. %s", i+1, preview), i)
+	options := make([]huh.Option[int], len(prompts.Prompts))
+	for i, p := range prompts.Prompts {
+		options[i] = huh.NewOption(p.label(60), i)
 	}
 
 	selectForm := newEscBackForm(
@@ -730,18 +1034,13 @@ func editCommand() {
 		return
 	}
 
-	editedPrompt := prompts[selectedIdx]
-
-	editForm := newEscBackForm(
-		huh.NewGroup(
-			huh.NewText().
-				Title("Edit the prompt").
-				Value(&editedPrompt).
-				CharLimit(2000),
-		),
-	)
+	edited := prompts.Prompts[selectedIdx]
+	if edited.Grader != nil {
+		grader := *edited.Grader
+		edited.Grader = &grader
+	}
 
-	aborted, err = runFormWithBack(editForm)
+	aborted, err = promptRecordForm("Edit the prompt", &edited)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -750,20 +1049,22 @@ func editCommand() {
 		return
 	}
 
-	editedPrompt = strings.TrimSpace(editedPrompt)
-	if editedPrompt == "" {
+	if edited.Text == "" {
 		fmt.Println("Prompt cannot be empty.")
 		os.Exit(1)
 	}
 
-	prompts[selectedIdx] = editedPrompt
+	if edited.Text != prompts.Prompts[selectedIdx].Text {
+		edited.Vars = detectPromptVars(edited.Text)
+	}
+	prompts.Prompts[selectedIdx] = edited
 
 	if err := savePrompts(prompts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error saving prompts: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Updated prompt #%d\n", selectedIdx+1)
+	fmt.Printf("Updated prompt #%d\n", edited.ID)
 }
 
 func removeCommand() {
@@ -773,19 +1074,15 @@ func removeCommand() {
 		os.Exit(1)
 	}
 
-	if len(prompts) == 0 {
+	if len(prompts.Prompts) == 0 {
 		fmt.Println("No prompts to remove.")
 		return
 	}
 
 	var selectedIdx int
-	options := make([]huh.Option[int], len(prompts))
-	for i, p := range prompts {
-		preview := p
-		if len(preview) > 60 {
-			preview = preview[:57] + "..."
-		}
-		options[i] = huh.NewOption(fmt.Sprintf("%d. %s", i+1, preview), i)
+	options := make([]huh.Option[int], len(prompts.Prompts))
+	for i, p := range prompts.Prompts {
+		options[i] = huh.NewOption(p.label(60), i)
 	}
 
 	form := newEscBackForm(
@@ -806,11 +1103,14 @@ func removeCommand() {
 		return
 	}
 
+	removedID := prompts.Prompts[selectedIdx].ID
+
 	var confirmRemove bool
 	confirmForm := newEscBackForm(
 		huh.NewGroup(
 			huh.NewConfirm().
-				Title(fmt.Sprintf("Remove prompt #%d?", selectedIdx+1)).
+				Title(fmt.Sprintf("Remove prompt #%d?", removedID)).
+				Description("Other prompts keep their IDs; this ID is never reused.").
 				Value(&confirmRemove),
 		),
 	)
@@ -829,66 +1129,120 @@ func removeCommand() {
 		return
 	}
 
-	prompts = append(prompts[:selectedIdx], prompts[selectedIdx+1:]...)
+	prompts.Prompts = append(prompts.Prompts[:selectedIdx], prompts.Prompts[selectedIdx+1:]...)
 
 	if err := savePrompts(prompts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error saving prompts: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Removed prompt #%d\n", selectedIdx+1)
+	fmt.Printf("Removed prompt #%d\n", removedID)
 }
 
-func runCommand() {
+func runCommand() int {
 	prompts, err := loadPrompts()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading prompts: %v\n", err)
-		os.Exit(1)
+		return exitSetupError
 	}
 
-	if len(prompts) == 0 {
-		fmt.Println("No prompts found. Use 'high-evals add' to add prompts first.")
-		os.Exit(1)
+	if len(prompts.Prompts) == 0 {
+		logf("No prompts found. Use 'high-evals add' to add prompts first.\n")
+		return exitSetupError
 	}
 
 	// Parse optional CLI flags for non-interactive mode
-	fs := flag.NewFlagSet("run", flag.ExitOnError)
-	flagModel := fs.String("m", "", "Model to use (e.g. opencode/kimi-k2.5-free)")
-	flagPrompts := fs.String("p", "", "Comma-separated 1-based prompt indices (e.g. 1,3,5)")
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	var flagModels modelFlags
+	fs.Var(&flagModels, "m", "Model(s) to use, comma-separated or repeated (e.g. opencode/kimi-k2.5-free)")
+	flagPrompts := fs.String("p", "", "Comma-separated prompt IDs (e.g. 1,3,5)")
 	flagMode := fs.String("mode", "sequential", "Execution mode: parallel or sequential")
 	flagInactivityTimeout := fs.Int("inactivity-timeout", int(defaultInactivityTimeout.Seconds()), "Inactivity timeout in seconds before failing a run")
 	flagRetries := fs.Int("retries", defaultTransientRetries, "Retries for transient failures (timeout/stream errors)")
-	if len(os.Args) > 2 {
-		fs.Parse(os.Args[2:])
+	flagConcurrency := fs.Int("concurrency", 0, "Maximum evals running at once in parallel mode (0 = all)")
+	flagTrials := fs.Int("trials", 1, "Independent runs per prompt and model")
+	flagOutput := fs.String("output", outputText, "Result output on stdout: text, json or jsonl")
+	flagBackend := fs.String("backend", defaultAgentBackend, "Agent backend to run evals with")
+	flagMinPassRate := fs.Float64("min-pass-rate", -1, "Exit with code 3 unless at least this fraction of evals pass (0-1)")
+	flagMaxFailures := fs.Int("max-failures", -1, "Exit with code 3 if more than this many evals fail")
+	flagMaxDuration := fs.Duration("max-duration", 0, "Abort an eval that runs longer than this (e.g. 30m, 0 = no limit)")
+	flagMaxCost := fs.Float64("max-cost", 0, "Abort an eval once its streamed cost exceeds this many USD (0 = no limit)")
+	var flagVars varFlags
+	fs.Var(&flagVars, "var", "Prompt variable as KEY=VALUE (repeatable)")
+	flagVarsFile := fs.String("vars-file", "", "JSON file with prompt variables ({\"KEY\": \"value\"})")
+	flagSuite := fs.String("suite", "", "Suite file (JSON) with prompts, models and run options")
+	if code, ok := parseCommandFlags(fs); !ok {
+		return code
+	}
+	var suite Suite
+	var suiteRef *SuiteRef
+	if *flagSuite != "" {
+		loaded, ref, err := loadSuite(*flagSuite)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading suite: %v\n", err)
+			return exitSetupError
+		}
+		if err := applySuiteFlags(fs, loaded); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitSetupError
+		}
+		suite, suiteRef = loaded, &ref
+	}
+	gate := passGate{MinPassRate: *flagMinPassRate, MaxFailures: *flagMaxFailures}
+	if err := gate.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
+	}
+	if *flagTrials < 1 {
+		fmt.Fprintf(os.Stderr, "Error: --trials must be at least 1\n")
+		return exitSetupError
+	}
+	if *flagConcurrency < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --concurrency must not be negative\n")
+		return exitSetupError
 	}
 	applyRuntimeOptions(*flagInactivityTimeout, *flagRetries)
+	if err := applyBudgets(*flagMaxDuration, *flagMaxCost); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
+	}
+	if err := applyOutputMode(*flagOutput); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
+	}
+	if _, err := newAgentBackend(*flagBackend); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
+	}
+	agentBackendName = *flagBackend
+	if err := applyPromptVars(suite.Vars, *flagVarsFile, flagVars); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading prompt variables: %v\n", err)
+		return exitSetupError
+	}
 
-	var selectedIndices []int
-	var modelStr string
+	var selectedIDs []int
+	var models []string
 	var runMode string
 
-	if *flagModel != "" && *flagPrompts != "" {
+	if len(flagModels) > 0 && *flagPrompts != "" {
 		// Non-interactive mode
-		modelStr = *flagModel
+		models = flagModels
 		runMode = *flagMode
 		for _, s := range strings.Split(*flagPrompts, ",") {
 			s = strings.TrimSpace(s)
-			idx, err := strconv.Atoi(s)
-			if err != nil || idx < 1 || idx > len(prompts) {
-				fmt.Fprintf(os.Stderr, "Invalid prompt index: %s (must be 1-%d)\n", s, len(prompts))
-				os.Exit(1)
+			id, err := strconv.Atoi(s)
+			if _, ok := prompts.find(id); err != nil || !ok {
+				fmt.Fprintf(os.Stderr, "Invalid prompt ID: %s (available: %s)\n", s, joinInts(prompts.ids(), ","))
+				return exitSetupError
 			}
-			selectedIndices = append(selectedIndices, idx-1)
+			selectedIDs = append(selectedIDs, id)
 		}
 	} else {
 		// Interactive mode
-		promptOptions := make([]huh.Option[int], len(prompts))
-		for i, p := range prompts {
-			preview := p
-			if len(preview) > 60 {
-				preview = preview[:57] + "..."
-			}
-			promptOptions[i] = huh.NewOption(fmt.Sprintf("%d. %s", i+1, preview), i)
+		interactiveSession = true
+		promptOptions := make([]huh.Option[int], len(prompts.Prompts))
+		for i, p := range prompts.Prompts {
+			promptOptions[i] = huh.NewOption(p.label(60), p.ID)
 		}
 
 		form := newEscBackForm(
@@ -896,7 +1250,7 @@ func runCommand() {
 				huh.NewMultiSelect[int]().
 					Title("Select prompts to run").
 					Options(promptOptions...).
-					Value(&selectedIndices).
+					Value(&selectedIDs).
 					Filterable(true),
 			),
 			huh.NewGroup(
@@ -913,91 +1267,1475 @@ func runCommand() {
 		aborted, err := runFormWithBack(form)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+			return exitSetupError
 		}
 		if aborted {
-			return
+			return exitOK
 		}
 
-		if len(selectedIndices) == 0 {
-			fmt.Println("No prompts selected.")
-			return
+		if len(selectedIDs) == 0 {
+			logf("No prompts selected.\n")
+			return exitOK
 		}
 
 		var modelSelectionAborted bool
-		modelStr, modelSelectionAborted = promptModelSelector("Select or type a model ID")
+		models, modelSelectionAborted = promptModelMultiSelector("Select one or more models to compare")
 		if modelSelectionAborted {
-			return
+			return exitOK
 		}
-		if modelStr == "" {
-			modelStr = "opencode/kimi-k2.5-free"
+		if len(models) == 0 {
+			models = []string{defaultEvalModel}
 		}
 	}
 
-	tasks := make([]EvalTask, len(selectedIndices))
-	for i, idx := range selectedIndices {
-		tasks[i] = EvalTask{
-			Prompt:       prompts[idx],
-			PromptNumber: idx + 1,
+	suiteGraders, err := parseGraderMap(suite.Graders, *flagSuite)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading suite: %v\n", err)
+		return exitSetupError
+	}
+
+	tasks := make([]EvalTask, 0, len(selectedIDs)*len(models)**flagTrials)
+	for _, id := range selectedIDs {
+		rec, _ := prompts.find(id)
+		for _, model := range models {
+			for trial := 1; trial <= *flagTrials; trial++ {
+				task := rec.task()
+				task.Model = model
+				task.Suite = suiteRef
+				if g, ok := suiteGraders[id]; ok {
+					task.Grader = g
+				}
+				if *flagTrials > 1 {
+					task.Trial = trial
+				}
+				tasks = append(tasks, task)
+			}
 		}
 	}
 
-	fmt.Printf("\nStarting %d eval(s) with model: %s\n", len(tasks), modelStr)
-	fmt.Printf("Mode: %s\n", runMode)
-	fmt.Printf("Inactivity timeout: %ds · transient retries: %d\n", int(inactivityTimeout.Seconds()), transientRetries)
-	fmt.Println(strings.Repeat("─", 50))
+	if err := checkPromptVars(tasks); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
+	}
+	if err := attachGraders(tasks); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading graders: %v\n", err)
+		return exitSetupError
+	}
+
+	if len(models) == 1 {
+		logf("\nStarting %d eval(s) with model: %s\n", len(tasks), models[0])
+	} else {
+		logf("\nStarting %d eval(s) across %d models: %s\n", len(tasks), len(models), strings.Join(models, ", "))
+	}
+	if suiteRef != nil {
+		logf("Suite: %s (%s)\n", suiteRef.Name, suiteRef.Hash)
+	}
+	if *flagTrials > 1 {
+		logf("Trials: %d per prompt and model\n", *flagTrials)
+	}
+	manifest := startRunManifest("run", fs, tasks)
+	logf("Mode: %s\n", describeRunMode(runMode, *flagConcurrency))
+	logf("Inactivity timeout: %ds · transient retries: %d\n", int(inactivityTimeout.Seconds()), transientRetries)
+	if budget := describeBudgets(); budget != "" {
+		logf("Budget per eval: %s\n", budget)
+	}
+	logf("%s\n", strings.Repeat("─", 50))
 
+	ctx, stopInterrupts := interruptContext()
+	defer stopInterrupts()
 	var results []EvalResult
 	if runMode == "parallel" {
-		results = runAllEvalsParallel(tasks, modelStr)
+		results = runAllEvalsParallel(ctx, tasks, *flagConcurrency)
 	} else {
-		results = runAllEvalsSequential(tasks, modelStr)
+		results = runAllEvalsSequential(ctx, tasks)
 	}
 
-	fmt.Printf("\n%s\n", strings.Repeat("═", 50))
-	fmt.Println("SUMMARY")
-	fmt.Println(strings.Repeat("═", 50))
-
-	for _, result := range results {
-		status := "✓"
-		if !result.Success {
-			status = "✗"
-		}
-		fmt.Printf("%s [%ds] %s\n", status, int(result.Duration.Seconds()), result.Folder)
-		if result.Error != "" {
-			fmt.Printf("  Error: %s\n", result.Error)
-		}
+	printResults(results)
+	code := gate.exitCode(results)
+	if ctx.Err() != nil {
+		code = exitInterrupted
 	}
+	manifest.finish(results, code)
+	return code
+}
 
-	successful := 0
-	for _, r := range results {
+// Suite is a named bundle of prompts, models and run options for
+// `run --suite`. Flags given on the command line win over suite values.
+type Suite struct {
+	Name                     string             `json:"name"`
+	Version                  int                `json:"version,omitempty"`
+	Prompts                  []int              `json:"prompts"`
+	Models                   []string           `json:"models"`
+	Mode                     string             `json:"mode,omitempty"`
+	Concurrency              int                `json:"concurrency,omitempty"`
+	Trials                   int                `json:"trials,omitempty"`
+	InactivityTimeoutSeconds int                `json:"inactivity_timeout_seconds,omitempty"`
+	Retries                  *int               `json:"retries,omitempty"`
+	Backend                  string             `json:"backend,omitempty"`
+	MinPassRate              *float64           `json:"min_pass_rate,omitempty"`
+	MaxFailures              *int               `json:"max_failures,omitempty"`
+	MaxDuration              string             `json:"max_duration,omitempty"`
+	MaxCostUSD               *float64           `json:"max_cost_usd,omitempty"`
+	Vars                     map[string]string  `json:"vars,omitempty"`
+	Graders                  map[string]*Grader `json:"graders,omitempty"`
+}
+
+// SuiteRef is stamped into result.json for evals started from a suite.
+type SuiteRef struct {
+	Name    string `json:"name"`
+	Version int    `json:"version,omitempty"`
+	Hash    string `json:"hash"`
+}
+
+func loadSuite(path string) (Suite, SuiteRef, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Suite{}, SuiteRef{}, err
+	}
+
+	var suite Suite
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&suite); err != nil {
+		return Suite{}, SuiteRef{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if suite.Name == "" {
+		suite.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if len(suite.Prompts) == 0 || len(suite.Models) == 0 {
+		return Suite{}, SuiteRef{}, fmt.Errorf("%s: suite needs at least one prompt and one model", path)
+	}
+
+	sum := sha256.Sum256(data)
+	ref := SuiteRef{Name: suite.Name, Version: suite.Version, Hash: "sha256:" + hex.EncodeToString(sum[:])}
+	return suite, ref, nil
+}
+
+// flagValues maps run flag names to the values this suite sets.
+func (s Suite) flagValues() map[string]string {
+	values := map[string]string{
+		"p": joinInts(s.Prompts, ","),
+		"m": strings.Join(s.Models, ","),
+	}
+	if s.Mode != "" {
+		values["mode"] = s.Mode
+	}
+	if s.Concurrency > 0 {
+		values["concurrency"] = strconv.Itoa(s.Concurrency)
+	}
+	if s.Trials > 0 {
+		values["trials"] = strconv.Itoa(s.Trials)
+	}
+	if s.InactivityTimeoutSeconds > 0 {
+		values["inactivity-timeout"] = strconv.Itoa(s.InactivityTimeoutSeconds)
+	}
+	if s.Retries != nil {
+		values["retries"] = strconv.Itoa(*s.Retries)
+	}
+	if s.Backend != "" {
+		values["backend"] = s.Backend
+	}
+	if s.MinPassRate != nil {
+		values["min-pass-rate"] = strconv.FormatFloat(*s.MinPassRate, 'f', -1, 64)
+	}
+	if s.MaxFailures != nil {
+		values["max-failures"] = strconv.Itoa(*s.MaxFailures)
+	}
+	if s.MaxDuration != "" {
+		values["max-duration"] = s.MaxDuration
+	}
+	if s.MaxCostUSD != nil {
+		values["max-cost"] = strconv.FormatFloat(*s.MaxCostUSD, 'f', -1, 64)
+	}
+	return values
+}
+
+// applySuiteFlags fills every flag the user did not pass explicitly from the
+// suite.
+func applySuiteFlags(fs *flag.FlagSet, suite Suite) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for name, value := range suite.flagValues() {
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("suite %s: invalid %s %q: %w", suite.Name, name, value, err)
+		}
+	}
+	return nil
+}
+
+// RunManifest records one run or resume invocation in runs/<id>/manifest.json.
+// It is written before the first eval starts, whenever an eval folder is
+// created, and again with the summary.
+type RunManifest struct {
+	RunID      string            `json:"run_id"`
+	Command    string            `json:"command"`
+	Version    string            `json:"version"`
+	Args       []string          `json:"args"`
+	Flags      map[string]string `json:"flags"`
+	Models     []string          `json:"models"`
+	Suite      *SuiteRef         `json:"suite,omitempty"`
+	StartedAt  string            `json:"started_at"`
+	FinishedAt string            `json:"finished_at,omitempty"`
+	Tasks      []ManifestTask    `json:"tasks"`
+	Summary    *RunSummary       `json:"summary,omitempty"`
+}
+
+type ManifestTask struct {
+	Index        int             `json:"index"`
+	PromptNumber int             `json:"prompt_number"`
+	Model        string          `json:"model"`
+	Trial        int             `json:"trial,omitempty"`
+	Folder       string          `json:"folder,omitempty"`
+	Success      *bool           `json:"success,omitempty"`
+	NotRun       bool            `json:"not_run,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Failure      FailureCategory `json:"failure,omitempty"`
+}
+
+// activeManifest is the manifest of the run in progress, if any. manifestMu
+// serializes its updates from concurrent evals.
+var (
+	activeManifest *RunManifest
+	manifestMu     sync.Mutex
+)
+
+type RunSummary struct {
+	Total           int     `json:"total"`
+	Passed          int     `json:"passed"`
+	Failed          int     `json:"failed"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	CostUSD         float64 `json:"cost_usd"`
+	ExitCode        int     `json:"exit_code"`
+}
+
+// startRunManifest assigns a new run ID to every task and writes the initial
+// manifest. A manifest that cannot be written is reported but does not stop
+// the run.
+func startRunManifest(command string, fs *flag.FlagSet, tasks []EvalTask) *RunManifest {
+	m := &RunManifest{
+		RunID:     newRunID(time.Now()),
+		Command:   command,
+		Version:   buildVersion(),
+		Args:      []string{},
+		Flags:     make(map[string]string),
+		StartedAt: time.Now().Format(time.RFC3339),
+	}
+	if len(os.Args) > 2 {
+		m.Args = redactVarArgs(os.Args[2:])
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if vars, ok := f.Value.(*varFlags); ok {
+			m.Flags[f.Name] = vars.redacted()
+			return
+		}
+		m.Flags[f.Name] = f.Value.String()
+	})
+
+	m.Models = taskModels(tasks)
+	for i := range tasks {
+		tasks[i].RunID = m.RunID
+		if m.Suite == nil {
+			m.Suite = tasks[i].Suite
+		}
+		m.Tasks = append(m.Tasks, ManifestTask{
+			Index:        i,
+			PromptNumber: tasks[i].PromptNumber,
+			Model:        tasks[i].Model,
+			Trial:        tasks[i].Trial,
+			Folder:       tasks[i].Folder,
+		})
+	}
+
+	if err := m.save(); err != nil {
+		logf("Warning: could not write run manifest: %v\n", err)
+	}
+	activeManifest = m
+	logf("Run ID: %s\n", m.RunID)
+	return m
+}
+
+func (m *RunManifest) finish(results []EvalResult, exitCode int) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	summary := &RunSummary{Total: len(results), ExitCode: exitCode}
+	for i, r := range results {
+		if r.Success {
+			summary.Passed++
+		} else {
+			summary.Failed++
+		}
+		summary.DurationSeconds += r.Duration.Seconds()
+		summary.CostUSD += r.CostUSD
+
+		if i >= len(m.Tasks) {
+			continue
+		}
+		if r.Folder != "" {
+			m.Tasks[i].Model = r.Model
+			m.Tasks[i].Folder = r.Folder
+		}
+		if r.Attempts == 0 {
+			m.Tasks[i].NotRun = true
+		} else {
+			success := r.Success
+			m.Tasks[i].Success = &success
+		}
+		m.Tasks[i].Error = r.Error
+		m.Tasks[i].Failure = r.Failure
+	}
+	m.Summary = summary
+	m.FinishedAt = time.Now().Format(time.RFC3339)
+	if activeManifest == m {
+		activeManifest = nil
+	}
+
+	if err := m.save(); err != nil {
+		logf("Warning: could not write run manifest: %v\n", err)
+		return
+	}
+	logf("Run manifest: %s\n", m.path())
+}
+
+// recordFolder stores the folder of task index as soon as it exists, so a run
+// that is killed before finish still maps its tasks to eval folders.
+func (m *RunManifest) recordFolder(index int, folder string) {
+	if m == nil {
+		return
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	if index < 0 || index >= len(m.Tasks) || m.Tasks[index].Folder == folder {
+		return
+	}
+	m.Tasks[index].Folder = folder
+	if err := m.save(); err != nil {
+		logf("Warning: could not write run manifest: %v\n", err)
+	}
+}
+
+func (m *RunManifest) path() string {
+	return filepath.Join(runsDir, m.RunID, "manifest.json")
+}
+
+func (m *RunManifest) save() error {
+	if err := os.MkdirAll(filepath.Dir(m.path()), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	return os.WriteFile(m.path(), buf.Bytes(), 0644)
+}
+
+// newRunID returns a sortable, collision-resistant ID such as
+// 20260213-201542-3f9a1c.
+func newRunID(now time.Time) string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// buildVersion reports the release version, falling back to the VCS revision
+// of development builds.
+func buildVersion() string {
+	if version != "dev" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && setting.Value != "" {
+				return "dev+" + setting.Value[:min(12, len(setting.Value))]
+			}
+		}
+	}
+	return version
+}
+
+func describeRunMode(runMode string, concurrency int) string {
+	if runMode == "parallel" && concurrency > 0 {
+		return fmt.Sprintf("parallel (concurrency %d)", concurrency)
+	}
+	return runMode
+}
+
+// parseCommandFlags parses the subcommand flags after os.Args[1]. It reports
+// false with the exit code to use when the command should stop.
+func parseCommandFlags(fs *flag.FlagSet) (int, bool) {
+	if len(os.Args) <= 2 {
+		return exitOK, true
+	}
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitSetupError, false
+	}
+	return exitOK, true
+}
+
+// passGate decides the exit code of run and resume. Negative fields are
+// unset; with neither set, any failed eval fails the command.
+type passGate struct {
+	MinPassRate float64
+	MaxFailures int
+}
+
+func (g passGate) validate() error {
+	if g.MinPassRate > 1 {
+		return fmt.Errorf("--min-pass-rate must be between 0 and 1, got %g", g.MinPassRate)
+	}
+	return nil
+}
+
+func (g passGate) exitCode(results []EvalResult) int {
+	failed := 0
+	for _, r := range results {
+		if !r.Success {
+			failed++
+		}
+	}
+
+	if g.MinPassRate < 0 && g.MaxFailures < 0 {
+		if failed > 0 {
+			return exitEvalsFailed
+		}
+		return exitOK
+	}
+
+	code := exitOK
+	if g.MinPassRate >= 0 && len(results) > 0 {
+		passRate := float64(len(results)-failed) / float64(len(results))
+		if passRate < g.MinPassRate {
+			logf("Threshold not met: pass rate %.1f%% is below %.1f%%\n", passRate*100, g.MinPassRate*100)
+			code = exitThresholdNotMet
+		}
+	}
+	if g.MaxFailures >= 0 && failed > g.MaxFailures {
+		logf("Threshold not met: %d failure(s) exceeds the maximum of %d\n", failed, g.MaxFailures)
+		code = exitThresholdNotMet
+	}
+	return code
+}
+
+func printResults(results []EvalResult) {
+	switch outputMode {
+	case outputJSON:
+		out := make([]EvalResultOutput, len(results))
+		for i, r := range results {
+			out[i] = newEvalResultOutput(r)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(out)
+	case outputJSONL:
+		// Every result was already streamed as a "finished" event.
+	default:
+		printSummary(results)
+	}
+}
+
+func printSummary(results []EvalResult) {
+	fmt.Printf("\n%s\n", strings.Repeat("═", 50))
+	fmt.Println("SUMMARY")
+	fmt.Println(strings.Repeat("═", 50))
+
+	for _, result := range results {
+		status := "✓"
+		if !result.Success {
+			status = "✗"
+		}
+		fmt.Printf("%s [%ds] %s\n", status, int(result.Duration.Seconds()), result.Folder)
+		if result.Failure != "" {
+			fmt.Printf("  Error (%s): %s\n", result.Failure, result.Error)
+		} else if result.Error != "" {
+			fmt.Printf("  Error: %s\n", result.Error)
+		}
+	}
+
+	if models := resultModels(results); len(models) > 1 {
+		fmt.Println()
+		printMatrixTable(os.Stdout, results, models)
+	}
+
+	if stats := trialStats(results); len(stats) > 0 {
+		fmt.Println()
+		printTrialStats(os.Stdout, stats)
+	}
+
+	successful, interrupted := 0, 0
+	for _, r := range results {
 		if r.Success {
 			successful++
 		}
+		if r.Failure == failureInterrupted {
+			interrupted++
+		}
 	}
 	fmt.Printf("\n%d/%d evals completed successfully\n", successful, len(results))
+	if interrupted > 0 {
+		fmt.Printf("%d eval(s) interrupted; rerun them with 'high-evals resume --failed'\n", interrupted)
+	}
+}
+
+// TrialStats summarizes repeated trials of one prompt on one model.
+type TrialStats struct {
+	PromptNumber  int
+	Model         string
+	Trials        int
+	Passed        int
+	PassAtK       float64
+	MeanSeconds   float64
+	StddevSeconds float64
+	CILow         float64
+	CIHigh        float64
+}
+
+// trialStats groups results that belong to --trials runs by prompt and model.
+func trialStats(results []EvalResult) []TrialStats {
+	type key struct {
+		promptNumber int
+		model        string
+	}
+	groups := make(map[key][]EvalResult)
+	var order []key
+	for _, r := range results {
+		if r.Trial == 0 {
+			continue
+		}
+		k := key{r.PromptNumber, r.Model}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], r)
+	}
+
+	stats := make([]TrialStats, 0, len(order))
+	for _, k := range order {
+		group := groups[k]
+		s := TrialStats{PromptNumber: k.promptNumber, Model: k.model, Trials: len(group)}
+		durations := make([]float64, len(group))
+		for i, r := range group {
+			if r.Success {
+				s.Passed++
+			}
+			durations[i] = r.Duration.Seconds()
+		}
+		s.PassAtK = passAtK(s.Trials, s.Passed, s.Trials)
+		s.MeanSeconds, s.StddevSeconds = meanStddev(durations)
+		s.CILow, s.CIHigh = wilsonInterval(s.Passed, s.Trials, 1.96)
+		stats = append(stats, s)
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].PromptNumber < stats[j].PromptNumber })
+	return stats
 }
 
-func resumeCommand() {
-	fs := flag.NewFlagSet("resume", flag.ExitOnError)
+func printTrialStats(w io.Writer, stats []TrialStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Prompt\tModel\tTrials\tpass@1\tpass@k\tDuration\t95% CI")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.2f\t%.2f\t%.0fs ± %.0fs\t[%.2f, %.2f]\n",
+			formatPromptNumber(s.PromptNumber), s.Model, s.Trials,
+			float64(s.Passed)/float64(s.Trials), s.PassAtK,
+			s.MeanSeconds, s.StddevSeconds, s.CILow, s.CIHigh)
+	}
+	tw.Flush()
+}
+
+// passAtK is the unbiased estimator of the chance that at least one of k
+// samples passes, given c passes out of n trials.
+func passAtK(n, c, k int) float64 {
+	if n <= 0 || k <= 0 {
+		return 0
+	}
+	if n-c < k {
+		return 1
+	}
+	fail := 1.0
+	for i := n - c + 1; i <= n; i++ {
+		fail *= 1 - float64(k)/float64(i)
+	}
+	return 1 - fail
+}
+
+// meanStddev returns the mean and sample standard deviation.
+func meanStddev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)-1))
+}
+
+// wilsonInterval is the Wilson score interval for a pass rate.
+func wilsonInterval(passed, n int, z float64) (float64, float64) {
+	if n == 0 {
+		return 0, 0
+	}
+	p := float64(passed) / float64(n)
+	nf := float64(n)
+	denom := 1 + z*z/nf
+	center := (p + z*z/(2*nf)) / denom
+	margin := z * math.Sqrt(p*(1-p)/nf+z*z/(4*nf*nf)) / denom
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
+
+func resultModels(results []EvalResult) []string {
+	seen := make(map[string]struct{})
+	models := make([]string, 0)
+	for _, r := range results {
+		if r.Model == "" {
+			continue
+		}
+		if _, exists := seen[r.Model]; exists {
+			continue
+		}
+		seen[r.Model] = struct{}{}
+		models = append(models, r.Model)
+	}
+	return models
+}
+
+// printMatrixTable renders results as a prompt × model grid.
+func printMatrixTable(w io.Writer, results []EvalResult, models []string) {
+	cells := make(map[int]map[string][]string)
+	promptNumbers := make([]int, 0)
+	for _, r := range results {
+		row, ok := cells[r.PromptNumber]
+		if !ok {
+			row = make(map[string][]string)
+			cells[r.PromptNumber] = row
+			promptNumbers = append(promptNumbers, r.PromptNumber)
+		}
+		status := "✓"
+		if !r.Success {
+			status = "✗"
+		}
+		row[r.Model] = append(row[r.Model], fmt.Sprintf("%s %ds", status, int(r.Duration.Seconds())))
+	}
+	sort.Ints(promptNumbers)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Prompt\t%s\n", strings.Join(models, "\t"))
+	for _, n := range promptNumbers {
+		label := "p?"
+		if n > 0 {
+			label = fmt.Sprintf("p%d", n)
+		}
+		row := make([]string, len(models))
+		for i, model := range models {
+			if entries := cells[n][model]; len(entries) > 0 {
+				row[i] = strings.Join(entries, ", ")
+			} else {
+				row[i] = "-"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", label, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+// ReportRow aggregates the evals of one model, or of one model on one prompt.
+// PromptNumber is 0 on leaderboard rows.
+type ReportRow struct {
+	Model                 string                  `json:"model"`
+	PromptNumber          int                     `json:"prompt_number,omitempty"`
+	Runs                  int                     `json:"runs"`
+	Passed                int                     `json:"passed"`
+	PassRate              float64                 `json:"pass_rate"`
+	MedianDurationSeconds float64                 `json:"median_duration_seconds"`
+	P90DurationSeconds    float64                 `json:"p90_duration_seconds"`
+	CostUSD               float64                 `json:"cost_usd"`
+	Retries               int                     `json:"retries"`
+	Flaky                 int                     `json:"flaky"`
+	Failures              map[FailureCategory]int `json:"failures,omitempty"`
+
+	durations []float64
+}
+
+type Report struct {
+	Leaderboard []ReportRow `json:"leaderboard"`
+	ByPrompt    []ReportRow `json:"by_prompt"`
+}
+
+func reportCommand(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	flagFormat := fs.String("format", "text", "Report format: text, markdown, csv or json")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitSetupError
+	}
+
+	switch *flagFormat {
+	case "text", "markdown", "csv", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid --format %q (use text, markdown, csv or json)\n", *flagFormat)
+		return exitSetupError
+	}
+
+	folders, err := scanEvalFolders()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error scanning evals: %v\n", err)
+		return exitSetupError
+	}
+
+	report := buildReport(folders)
+	if len(report.Leaderboard) == 0 {
+		logf("No finished evals found in evals/.\n")
+	}
+
+	switch *flagFormat {
+	case "markdown":
+		writeReportMarkdown(os.Stdout, report)
+	case "csv":
+		if err := writeReportCSV(os.Stdout, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing CSV: %v\n", err)
+			return exitSetupError
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	default:
+		writeReportText(os.Stdout, report)
+	}
+	return exitOK
+}
+
+// buildReport groups finished evals by model and by model × prompt number.
+// Folders without a result.json are skipped.
+func buildReport(folders []EvalFolder) Report {
+	byModel := make(map[string]*ReportRow)
+	byPrompt := make(map[string]*ReportRow)
+
+	for _, f := range folders {
+		if f.Result == nil {
+			continue
+		}
+		model := f.Result.Model
+		if model == "" {
+			model = "unknown"
+		}
+		promptKey := fmt.Sprintf("%s\x00%d", model, f.PromptNumber)
+		if byModel[model] == nil {
+			byModel[model] = &ReportRow{Model: model}
+		}
+		if byPrompt[promptKey] == nil {
+			byPrompt[promptKey] = &ReportRow{Model: model, PromptNumber: f.PromptNumber}
+		}
+		for _, row := range []*ReportRow{byModel[model], byPrompt[promptKey]} {
+			row.add(*f.Result)
+		}
+	}
+
+	var report Report
+	for _, row := range byModel {
+		report.Leaderboard = append(report.Leaderboard, row.finish())
+	}
+	for _, row := range byPrompt {
+		report.ByPrompt = append(report.ByPrompt, row.finish())
+	}
+
+	sort.Slice(report.Leaderboard, func(i, j int) bool {
+		a, b := report.Leaderboard[i], report.Leaderboard[j]
+		if a.PassRate != b.PassRate {
+			return a.PassRate > b.PassRate
+		}
+		if a.Passed != b.Passed {
+			return a.Passed > b.Passed
+		}
+		if a.CostUSD != b.CostUSD {
+			return a.CostUSD < b.CostUSD
+		}
+		return a.Model < b.Model
+	})
+	sort.Slice(report.ByPrompt, func(i, j int) bool {
+		a, b := report.ByPrompt[i], report.ByPrompt[j]
+		if a.PromptNumber != b.PromptNumber {
+			return a.PromptNumber < b.PromptNumber
+		}
+		return a.Model < b.Model
+	})
+	return report
+}
+
+func (r *ReportRow) add(rf EvalResultFile) {
+	r.Runs++
+	if rf.Success {
+		r.Passed++
+	}
+	r.CostUSD += rf.CostUSD
+	if rf.Attempts > 1 {
+		r.Retries += rf.Attempts - 1
+	}
+	if rf.History.flaky() {
+		r.Flaky++
+	}
+	if !rf.Success && rf.Failure != "" {
+		if r.Failures == nil {
+			r.Failures = make(map[FailureCategory]int)
+		}
+		r.Failures[rf.Failure]++
+	}
+	r.durations = append(r.durations, float64(rf.DurationSeconds))
+}
+
+func (r *ReportRow) finish() ReportRow {
+	row := *r
+	if row.Runs > 0 {
+		row.PassRate = float64(row.Passed) / float64(row.Runs)
+	}
+	sort.Float64s(row.durations)
+	row.MedianDurationSeconds = median(row.durations)
+	row.P90DurationSeconds = percentile(row.durations, 0.9)
+	row.durations = nil
+	return row
+}
+
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// percentile uses the nearest-rank method on an ascending slice.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func writeReportText(w io.Writer, report Report) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LEADERBOARD")
+	fmt.Fprintln(tw, "#\tMODEL\tRUNS\tPASS\tMEDIAN\tP90\tCOST\tRETRIES\tFLAKY\tFAILURES")
+	for i, row := range report.Leaderboard {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", i+1, row.Model, row.Runs,
+			formatPassRate(row), formatSeconds(row.MedianDurationSeconds), formatSeconds(row.P90DurationSeconds),
+			formatCost(row.CostUSD), row.Retries, row.Flaky, formatFailures(row.Failures, " ", "-"))
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "BY PROMPT")
+	fmt.Fprintln(tw, "PROMPT\tMODEL\tRUNS\tPASS\tMEDIAN\tP90\tCOST\tRETRIES\tFLAKY\tFAILURES")
+	for _, row := range report.ByPrompt {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", formatPromptNumber(row.PromptNumber), row.Model, row.Runs,
+			formatPassRate(row), formatSeconds(row.MedianDurationSeconds), formatSeconds(row.P90DurationSeconds),
+			formatCost(row.CostUSD), row.Retries, row.Flaky, formatFailures(row.Failures, " ", "-"))
+	}
+	tw.Flush()
+}
+
+func writeReportMarkdown(w io.Writer, report Report) {
+	fmt.Fprintln(w, "## Leaderboard")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| # | Model | Runs | Pass | Median | P90 | Cost | Retries | Flaky | Failures |")
+	fmt.Fprintln(w, "|---|---|---|---|---|---|---|---|---|---|")
+	for i, row := range report.Leaderboard {
+		fmt.Fprintf(w, "| %d | `%s` | %d | %s | %s | %s | %s | %d | %d | %s |\n", i+1, row.Model, row.Runs,
+			formatPassRate(row), formatSeconds(row.MedianDurationSeconds), formatSeconds(row.P90DurationSeconds),
+			formatCost(row.CostUSD), row.Retries, row.Flaky, formatFailures(row.Failures, ", ", "-"))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "## By prompt")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| Prompt | Model | Runs | Pass | Median | P90 | Cost | Retries | Flaky | Failures |")
+	fmt.Fprintln(w, "|---|---|---|---|---|---|---|---|---|---|")
+	for _, row := range report.ByPrompt {
+		fmt.Fprintf(w, "| %s | `%s` | %d | %s | %s | %s | %s | %d | %d | %s |\n", formatPromptNumber(row.PromptNumber), row.Model, row.Runs,
+			formatPassRate(row), formatSeconds(row.MedianDurationSeconds), formatSeconds(row.P90DurationSeconds),
+			formatCost(row.CostUSD), row.Retries, row.Flaky, formatFailures(row.Failures, ", ", "-"))
+	}
+}
+
+func writeReportCSV(w io.Writer, report Report) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"scope", "model", "prompt_number", "runs", "passed", "pass_rate",
+		"median_duration_seconds", "p90_duration_seconds", "cost_usd", "retries", "flaky", "failures"})
+	write := func(scope string, row ReportRow) {
+		promptNumber := ""
+		if scope == "prompt" {
+			promptNumber = strconv.Itoa(row.PromptNumber)
+		}
+		cw.Write([]string{scope, row.Model, promptNumber, strconv.Itoa(row.Runs), strconv.Itoa(row.Passed),
+			strconv.FormatFloat(row.PassRate, 'f', 4, 64),
+			strconv.FormatFloat(row.MedianDurationSeconds, 'f', -1, 64),
+			strconv.FormatFloat(row.P90DurationSeconds, 'f', -1, 64),
+			strconv.FormatFloat(row.CostUSD, 'f', 4, 64), strconv.Itoa(row.Retries), strconv.Itoa(row.Flaky),
+			formatFailures(row.Failures, ";", "")})
+	}
+	for _, row := range report.Leaderboard {
+		write("model", row)
+	}
+	for _, row := range report.ByPrompt {
+		write("prompt", row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatFailures lists failure counts as "inactivity:2", most frequent first.
+func formatFailures(failures map[FailureCategory]int, sep, empty string) string {
+	if len(failures) == 0 {
+		return empty
+	}
+	categories := make([]FailureCategory, 0, len(failures))
+	for c := range failures {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		a, b := categories[i], categories[j]
+		if failures[a] != failures[b] {
+			return failures[a] > failures[b]
+		}
+		return a < b
+	})
+	parts := make([]string, len(categories))
+	for i, c := range categories {
+		parts[i] = fmt.Sprintf("%s:%d", c, failures[c])
+	}
+	return strings.Join(parts, sep)
+}
+
+func formatPassRate(row ReportRow) string {
+	return fmt.Sprintf("%d/%d (%.0f%%)", row.Passed, row.Runs, row.PassRate*100)
+}
+
+func formatSeconds(s float64) string {
+	return fmt.Sprintf("%ds", int(math.Round(s)))
+}
+
+func formatCost(usd float64) string {
+	return fmt.Sprintf("$%.4f", usd)
+}
+
+func formatPromptNumber(n int) string {
+	if n < 1 {
+		return "?"
+	}
+	return fmt.Sprintf("p%d", n)
+}
+
+// DiffEntry compares the evals of one prompt and model between two sets.
+// Change is one of regression, fixed, unchanged, added or removed.
+type DiffEntry struct {
+	PromptNumber     int     `json:"prompt_number"`
+	Model            string  `json:"model"`
+	Change           string  `json:"change"`
+	ARuns            int     `json:"a_runs"`
+	APassed          int     `json:"a_passed"`
+	BRuns            int     `json:"b_runs"`
+	BPassed          int     `json:"b_passed"`
+	ADurationSeconds float64 `json:"a_duration_seconds"`
+	BDurationSeconds float64 `json:"b_duration_seconds"`
+	ACostUSD         float64 `json:"a_cost_usd"`
+	BCostUSD         float64 `json:"b_cost_usd"`
+	AMissing         int     `json:"a_missing,omitempty"`
+	BMissing         int     `json:"b_missing,omitempty"`
+}
+
+func diffCommand(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flagFormat := fs.String("format", "text", "Output format: text or json")
+	flagFailOnMissing := fs.Bool("fail-on-missing", false, "Also exit with code 4 when a pair of A is missing from B")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: high-evals diff [--format text|json] [--fail-on-missing] <runA> <runB>")
+		fmt.Fprintln(os.Stderr, "Each side is a run ID from runs/ or a glob of eval folders (e.g. 'evals/2026-02-13_*').")
+		fs.PrintDefaults()
+	}
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitSetupError
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return exitSetupError
+	}
+	if *flagFormat != "text" && *flagFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Error: invalid --format %q (use text or json)\n", *flagFormat)
+		return exitSetupError
+	}
+
+	sides := make([][]EvalFolder, 2)
+	for i, arg := range positional {
+		folders, err := resolveEvalSet(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitSetupError
+		}
+		sides[i] = folders
+	}
+
+	entries := diffEvalSets(sides[0], sides[1])
+	if *flagFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+	} else {
+		writeDiffText(os.Stdout, entries)
+	}
+
+	for _, e := range entries {
+		if e.Change == "regression" || (*flagFailOnMissing && e.Change == "removed") {
+			return exitRegression
+		}
+	}
+	return exitOK
+}
+
+// parseInterspersed parses flags that appear before, between or after the
+// positional arguments, which flag.FlagSet.Parse stops at.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// resolveEvalSet returns the finished evals of a run ID (runs/<id>/manifest.json)
+// or of the eval folders matching a glob. A run ID reports the attempts that
+// run recorded, since a later resume of its folders rewrites result.json, and
+// reports its tasks without such an attempt as missing.
+func resolveEvalSet(arg string) ([]EvalFolder, error) {
+	var paths []string
+	var runID string
+	var tasks []ManifestTask
+	if data, err := os.ReadFile(filepath.Join(runsDir, arg, "manifest.json")); err == nil {
+		var manifest RunManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("parsing manifest of run %s: %w", arg, err)
+		}
+		runID = manifest.RunID
+		if runID == "" {
+			runID = arg
+		}
+		tasks = manifest.Tasks
+	} else {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+		}
+		paths = matches
+	}
+
+	promptNumberByText := buildPromptNumberByPrompt()
+	var folders []EvalFolder
+	for _, path := range paths {
+		if ef, ok := readEvalFolder(path, promptNumberByText); ok && ef.Result != nil {
+			folders = append(folders, ef)
+		}
+	}
+	var seen []string
+	for _, task := range tasks {
+		if task.Folder != "" {
+			if containsString(seen, task.Folder) {
+				continue
+			}
+			seen = append(seen, task.Folder)
+			if ef, ok := readEvalFolder(task.Folder, promptNumberByText); ok && ef.Result != nil {
+				if ef.Result, ok = runAttemptResult(task.Folder, *ef.Result, runID); ok {
+					folders = append(folders, ef)
+					continue
+				}
+			}
+		}
+		folders = append(folders, EvalFolder{
+			Path:         task.Folder,
+			PromptNumber: task.PromptNumber,
+			Missing:      true,
+			Result: &EvalResultFile{
+				Model:   task.Model,
+				Error:   fmt.Sprintf("run %s recorded no result", runID),
+				Failure: failureInterrupted,
+				RunID:   runID,
+			},
+		})
+	}
+	if len(folders) == 0 {
+		return nil, fmt.Errorf("no finished evals found for %q (expected a run ID or eval folder glob)", arg)
+	}
+	return folders, nil
+}
+
+// runAttemptResult returns rf with the outcome of the last attempt runID
+// recorded in folderPath, or false if that run finished no attempt there.
+func runAttemptResult(folderPath string, rf EvalResultFile, runID string) (*EvalResultFile, bool) {
+	records, err := loadAttempts(folderPath)
+	if err != nil {
+		logf("Warning: could not read attempts of %s: %v\n", folderPath, err)
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		rec := records[i]
+		if rec.RunID != runID {
+			continue
+		}
+		rf.RunID = rec.RunID
+		rf.Model = rec.Model
+		rf.Success = rec.Success
+		rf.Error = rec.Error
+		rf.Failure = rec.Failure
+		rf.DurationSeconds = rec.DurationSeconds
+		rf.CostUSD = rec.CostUSD
+		rf.CompletedAt = rec.CompletedAt
+		rf.Attempts = rec.Retry + 1
+		return &rf, true
+	}
+	if rf.RunID == runID {
+		return &rf, true
+	}
+	return nil, false
+}
+
+// diffEvalSets matches evals by prompt number and model. Several evals of the
+// same pair (trials) are compared by pass rate and mean duration and cost.
+func diffEvalSets(a, b []EvalFolder) []DiffEntry {
+	type key struct {
+		promptNumber int
+		model        string
+	}
+	entries := make(map[key]*DiffEntry)
+	var keys []key
+	add := func(folders []EvalFolder, sideA bool) {
+		for _, f := range folders {
+			k := key{f.PromptNumber, f.Result.Model}
+			e, ok := entries[k]
+			if !ok {
+				e = &DiffEntry{PromptNumber: k.promptNumber, Model: k.model}
+				entries[k] = e
+				keys = append(keys, k)
+			}
+			runs, passed, missing, duration, cost := &e.BRuns, &e.BPassed, &e.BMissing, &e.BDurationSeconds, &e.BCostUSD
+			if sideA {
+				runs, passed, missing, duration, cost = &e.ARuns, &e.APassed, &e.AMissing, &e.ADurationSeconds, &e.ACostUSD
+			}
+			*runs++
+			if f.Missing {
+				*missing++
+				continue
+			}
+			if f.Result.Success {
+				*passed++
+			}
+			*duration += float64(f.Result.DurationSeconds)
+			*cost += f.Result.CostUSD
+		}
+	}
+	add(a, true)
+	add(b, false)
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].promptNumber != keys[j].promptNumber {
+			return keys[i].promptNumber < keys[j].promptNumber
+		}
+		return keys[i].model < keys[j].model
+	})
+
+	result := make([]DiffEntry, 0, len(keys))
+	for _, k := range keys {
+		e := entries[k]
+		if n := e.ARuns - e.AMissing; n > 0 {
+			e.ADurationSeconds /= float64(n)
+			e.ACostUSD /= float64(n)
+		}
+		if n := e.BRuns - e.BMissing; n > 0 {
+			e.BDurationSeconds /= float64(n)
+			e.BCostUSD /= float64(n)
+		}
+
+		switch {
+		case e.ARuns == 0:
+			e.Change = "added"
+		case e.BRuns == 0:
+			e.Change = "removed"
+		default:
+			rateA := float64(e.APassed) / float64(e.ARuns)
+			rateB := float64(e.BPassed) / float64(e.BRuns)
+			switch {
+			case rateB < rateA:
+				e.Change = "regression"
+			case rateB > rateA:
+				e.Change = "fixed"
+			default:
+				e.Change = "unchanged"
+			}
+		}
+		result = append(result, *e)
+	}
+	return result
+}
+
+func writeDiffText(w io.Writer, entries []DiffEntry) {
+	counts := make(map[string]int)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROMPT\tMODEL\tA\tB\tCHANGE\tDURATION\tCOST")
+	for _, e := range entries {
+		counts[e.Change]++
+		duration, cost := "-", "-"
+		if e.ARuns > e.AMissing && e.BRuns > e.BMissing {
+			duration = fmt.Sprintf("%s → %s (%+ds)", formatSeconds(e.ADurationSeconds), formatSeconds(e.BDurationSeconds),
+				int(math.Round(e.BDurationSeconds-e.ADurationSeconds)))
+			cost = fmt.Sprintf("%s → %s (%+.4f)", formatCost(e.ACostUSD), formatCost(e.BCostUSD), e.BCostUSD-e.ACostUSD)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", formatPromptNumber(e.PromptNumber), e.Model,
+			formatDiffStatus(e.APassed, e.ARuns, e.AMissing), formatDiffStatus(e.BPassed, e.BRuns, e.BMissing), e.Change, duration, cost)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d regression(s), %d fixed, %d unchanged, %d added, %d removed\n",
+		counts["regression"], counts["fixed"], counts["unchanged"], counts["added"], counts["removed"])
+}
+
+func formatDiffStatus(passed, runs, missing int) string {
+	switch {
+	case runs == 0:
+		return "-"
+	case missing == runs:
+		return "missing"
+	case runs > 1:
+		return fmt.Sprintf("%d/%d", passed, runs)
+	case passed == 1:
+		return "pass"
+	}
+	return "fail"
+}
+
+func resumeCommand() int {
+	fs := flag.NewFlagSet("resume", flag.ContinueOnError)
 	flagInactivityTimeout := fs.Int("inactivity-timeout", int(defaultInactivityTimeout.Seconds()), "Inactivity timeout in seconds before failing a run")
 	flagRetries := fs.Int("retries", defaultTransientRetries, "Retries for transient failures (timeout/stream errors)")
-	if len(os.Args) > 2 {
-		fs.Parse(os.Args[2:])
+	flagConcurrency := fs.Int("concurrency", 0, "Maximum evals running at once in parallel mode (0 = all)")
+	flagOutput := fs.String("output", outputText, "Result output on stdout: text, json or jsonl")
+	flagBackend := fs.String("backend", defaultAgentBackend, "Agent backend to run evals with")
+	flagMinPassRate := fs.Float64("min-pass-rate", -1, "Exit with code 3 unless at least this fraction of evals pass (0-1)")
+	flagMaxFailures := fs.Int("max-failures", -1, "Exit with code 3 if more than this many evals fail")
+	flagMaxDuration := fs.Duration("max-duration", 0, "Abort an eval that runs longer than this (e.g. 30m, 0 = no limit)")
+	flagMaxCost := fs.Float64("max-cost", 0, "Abort an eval once its streamed cost exceeds this many USD (0 = no limit)")
+	var flagVars varFlags
+	fs.Var(&flagVars, "var", "Prompt variable as KEY=VALUE (repeatable)")
+	flagVarsFile := fs.String("vars-file", "", "JSON file with prompt variables ({\"KEY\": \"value\"})")
+	flagMode := fs.String("mode", "sequential", "Execution mode when selecting with filters: parallel or sequential")
+	flagFailed := fs.Bool("failed", false, "Select evals whose result.json reports a failure")
+	flagIncomplete := fs.Bool("incomplete", false, "Select evals without a result.json")
+	flagFlaky := fs.Bool("flaky", false, "Select evals that both passed and failed across attempts")
+	flagFailure := fs.String("failure", "", "Select failed evals in these failure categories (e.g. inactivity,stream)")
+	var flagFilterModels modelFlags
+	fs.Var(&flagFilterModels, "model", "Select evals run with this model (comma-separated or repeated)")
+	flagFilterPrompts := fs.String("prompt", "", "Select evals of these prompt IDs (e.g. 3,5)")
+	flagSince := fs.String("since", "", "Select evals started on or after this date (2026-10-01 or RFC3339)")
+	flagFolders := fs.String("folders", "", "Select eval folders matching this glob (e.g. 'evals/2026-10-*')")
+	flagFresh := fs.Bool("fresh", false, "Archive each folder's previous contents to <folder>.attempts/<N>/ and start from a clean scaffold")
+	if code, ok := parseCommandFlags(fs); !ok {
+		return code
+	}
+	gate := passGate{MinPassRate: *flagMinPassRate, MaxFailures: *flagMaxFailures}
+	if err := gate.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
+	}
+	if *flagConcurrency < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --concurrency must not be negative\n")
+		return exitSetupError
+	}
+	filter, err := newResumeFilter(*flagFailed, *flagIncomplete, *flagFlaky, *flagFailure, flagFilterModels, *flagFilterPrompts, *flagSince, *flagFolders)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
 	}
 	applyRuntimeOptions(*flagInactivityTimeout, *flagRetries)
+	if err := applyBudgets(*flagMaxDuration, *flagMaxCost); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
+	}
+	if err := applyOutputMode(*flagOutput); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
+	}
+	if _, err := newAgentBackend(*flagBackend); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
+	}
+	agentBackendName = *flagBackend
+	if err := applyPromptVars(nil, *flagVarsFile, flagVars); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading prompt variables: %v\n", err)
+		return exitSetupError
+	}
 
 	folders, err := scanEvalFolders()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error scanning eval folders: %v\n", err)
-		os.Exit(1)
+		return exitSetupError
 	}
 
 	if len(folders) == 0 {
-		fmt.Println("No eval folders found in evals/. Run 'high-evals run' first.")
-		return
+		logf("No eval folders found in evals/. Run 'high-evals run' first.\n")
+		return exitOK
+	}
+
+	var selectedIndices []int
+	var modelStr string
+	runMode := *flagMode
+	fresh := *flagFresh
+
+	if filter.active() {
+		selectedIndices = filter.selectFolders(folders)
+		if len(selectedIndices) == 0 {
+			logf("No evals match the resume filters.\n")
+			return exitOK
+		}
+	} else {
+		var ok bool
+		interactiveSession = true
+		selectedIndices, modelStr, runMode, fresh, ok, err = promptResumeSelection(folders, fresh)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitSetupError
+		}
+		if !ok {
+			return exitOK
+		}
+	}
+	// modelStr may be empty — handled below per-eval
+
+	prompts, err := loadPrompts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading prompts: %v\n", err)
+		return exitSetupError
+	}
+	tasks := make([]EvalTask, len(selectedIndices))
+	for i, idx := range selectedIndices {
+		ef := folders[idx]
+		tasks[i] = EvalTask{
+			Prompt:       ef.Prompt,
+			PromptNumber: ef.PromptNumber,
+			Folder:       ef.Path,
+			Fresh:        fresh,
+		}
+		if ef.Result != nil {
+			tasks[i].Trial = ef.Result.Trial
+			tasks[i].Suite = ef.Result.Suite
+		}
+		tasks[i].Model = resumeModel(modelStr, ef)
+		// A folder whose prompt was edited since keeps the variables it used.
+		tasks[i].Vars = detectPromptVars(ef.Prompt)
+		if rec, ok := prompts.find(ef.PromptNumber); ok {
+			tasks[i].Grader = rec.Grader
+			if rec.Text == ef.Prompt {
+				tasks[i].Vars = rec.Vars
+			}
+		}
+	}
+
+	if err := checkPromptVars(tasks); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitSetupError
+	}
+	if err := attachGraders(tasks); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading graders: %v\n", err)
+		return exitSetupError
+	}
+
+	if modelStr != "" {
+		logf("\nResuming %d eval(s) with model: %s\n", len(tasks), modelStr)
+	} else {
+		logf("\nResuming %d eval(s) with their recorded models: %s\n", len(tasks), strings.Join(taskModels(tasks), ", "))
+	}
+	manifest := startRunManifest("resume", fs, tasks)
+	logf("Mode: %s\n", describeRunMode(runMode, *flagConcurrency))
+	if fresh {
+		logf("Workspace: fresh (previous contents archived under %s/)\n", attemptsDir)
+	} else {
+		logf("Workspace: keep building on previous state\n")
+	}
+	logf("Inactivity timeout: %ds · transient retries: %d\n", int(inactivityTimeout.Seconds()), transientRetries)
+	if budget := describeBudgets(); budget != "" {
+		logf("Budget per eval: %s\n", budget)
+	}
+	logf("%s\n", strings.Repeat("─", 50))
+
+	ctx, stopInterrupts := interruptContext()
+	defer stopInterrupts()
+	var results []EvalResult
+	if runMode == "parallel" {
+		results = runAllEvalsParallel(ctx, tasks, *flagConcurrency)
+	} else {
+		results = runAllEvalsSequential(ctx, tasks)
+	}
+
+	printResults(results)
+	code := gate.exitCode(results)
+	if ctx.Err() != nil {
+		code = exitInterrupted
+	}
+	manifest.finish(results, code)
+	return code
+}
+
+// resumeModel picks the model to re-run a folder with: the override when set,
+// otherwise the model recorded in its result.json.
+func resumeModel(override string, ef EvalFolder) string {
+	if override != "" {
+		return override
+	}
+	if ef.Result != nil && ef.Result.Model != "" {
+		return ef.Result.Model
+	}
+	return defaultEvalModel
+}
+
+func taskModels(tasks []EvalTask) []string {
+	var models []string
+	for _, task := range tasks {
+		if !containsString(models, task.Model) {
+			models = append(models, task.Model)
+		}
 	}
+	return models
+}
 
+// promptResumeSelection asks which eval folders to resume, the execution mode,
+// whether to reset the workspace and an optional model override. It reports
+// false when the user backs out.
+func promptResumeSelection(folders []EvalFolder, freshDefault bool) (selectedIndices []int, modelStr, runMode string, fresh, ok bool, err error) {
+	fresh = freshDefault
 	options := make([]huh.Option[int], len(folders))
 	for i, ef := range folders {
 		status := "?"
@@ -1009,6 +2747,15 @@ func resumeCommand() {
 				status = "✗"
 			}
 			extra = fmt.Sprintf(" [%s, %ds]", ef.Result.Model, ef.Result.DurationSeconds)
+			if ef.Result.Failure != "" {
+				extra = fmt.Sprintf(" [%s, %ds, %s]", ef.Result.Model, ef.Result.DurationSeconds, ef.Result.Failure)
+			}
+			if h := ef.Result.History; h != nil && h.Attempts > 1 {
+				extra += fmt.Sprintf(" %d/%d passed", h.Passed, h.Attempts)
+				if h.flaky() {
+					extra += " flaky"
+				}
+			}
 		}
 
 		preview := ef.Prompt
@@ -1025,15 +2772,11 @@ func resumeCommand() {
 		options[i] = huh.NewOption(label, i)
 	}
 
-	var selectedIndices []int
-	var modelStr string
-	var runMode string
-
 	form := newEscBackForm(
 		huh.NewGroup(
 			huh.NewMultiSelect[int]().
 				Title("Select evals to resume").
-				Description("✓ = succeeded, ✗ = failed, ? = incomplete").
+				Description("✓ = succeeded, ✗ = failed, ? = incomplete; flaky = passed and failed across attempts").
 				Options(options...).
 				Value(&selectedIndices).
 				Filterable(true),
@@ -1046,87 +2789,161 @@ func resumeCommand() {
 					huh.NewOption("Sequential (run one at a time)", "sequential"),
 				).
 				Value(&runMode),
+			huh.NewSelect[bool]().
+				Title("Workspace").
+				Options(
+					huh.NewOption("Keep building on the previous state", false),
+					huh.NewOption("Start fresh (archive previous contents to <folder>.attempts/)", true),
+				).
+				Value(&fresh),
 		),
 	)
 
 	aborted, err := runFormWithBack(form)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
-	if aborted {
-		return
+	if err != nil || aborted {
+		return nil, "", "", false, false, err
 	}
 
 	if len(selectedIndices) == 0 {
-		fmt.Println("No evals selected.")
-		return
+		logf("No evals selected.\n")
+		return nil, "", "", false, false, nil
 	}
 
-	var modelSelectionAborted bool
-	modelStr, modelSelectionAborted = promptModelSelector("Select a model, or leave empty to re-use original")
+	modelStr, modelSelectionAborted := promptModelSelector("Select a model, or leave empty to re-use original")
 	if modelSelectionAborted {
-		return
+		return nil, "", "", false, false, nil
 	}
-	// modelStr may be empty — handled below per-eval
+	return selectedIndices, modelStr, runMode, fresh, true, nil
+}
 
-	tasks := make([]EvalTask, len(selectedIndices))
-	for i, idx := range selectedIndices {
-		ef := folders[idx]
-		tasks[i] = EvalTask{
-			Prompt:       ef.Prompt,
-			PromptNumber: ef.PromptNumber,
-			Folder:       ef.Path,
-		}
+// resumeFilter selects eval folders for `resume` without the TUI. --failed,
+// --incomplete and --flaky widen the status match; all other filters narrow it.
+type resumeFilter struct {
+	Failed      bool
+	Incomplete  bool
+	Flaky       bool
+	Failures    []FailureCategory
+	Models      []string
+	Prompts     []int
+	Since       time.Time
+	FoldersGlob string
+}
 
-		if modelStr == "" && ef.Result != nil && ef.Result.Model != "" {
-			if i == 0 {
-				modelStr = ef.Result.Model
+func newResumeFilter(failed, incomplete, flaky bool, failures string, models []string, prompts, since, folders string) (resumeFilter, error) {
+	f := resumeFilter{Failed: failed, Incomplete: incomplete, Flaky: flaky, Models: models, FoldersGlob: folders}
+	categories, err := parseFailureCategories(failures)
+	if err != nil {
+		return resumeFilter{}, fmt.Errorf("invalid --failure: %w", err)
+	}
+	f.Failures = categories
+	for _, s := range strings.Split(prompts, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return resumeFilter{}, fmt.Errorf("invalid --prompt %q (expected prompt IDs like 3,5)", prompts)
+		}
+		f.Prompts = append(f.Prompts, n)
+	}
+	if since != "" {
+		t, err := time.ParseInLocation("2006-01-02", since, time.Local)
+		if err != nil {
+			if t, err = time.Parse(time.RFC3339, since); err != nil {
+				return resumeFilter{}, fmt.Errorf("invalid --since %q (use 2026-10-01 or RFC3339)", since)
 			}
 		}
+		f.Since = t
 	}
-
-	if modelStr == "" {
-		modelStr = "opencode/kimi-k2.5-free"
+	if folders != "" {
+		if _, err := filepath.Match(folders, ""); err != nil {
+			return resumeFilter{}, fmt.Errorf("invalid --folders pattern %q: %w", folders, err)
+		}
 	}
+	return f, nil
+}
 
-	// If user set a model, use it for all. If not, we already picked one above.
-	// For per-eval model tracking, the model is saved in result.json per folder.
+func (f resumeFilter) active() bool {
+	return f.Failed || f.Incomplete || f.Flaky || len(f.Failures) > 0 || len(f.Models) > 0 || len(f.Prompts) > 0 || !f.Since.IsZero() || f.FoldersGlob != ""
+}
 
-	fmt.Printf("\nResuming %d eval(s) with model: %s\n", len(tasks), modelStr)
-	fmt.Printf("Mode: %s\n", runMode)
-	fmt.Printf("Inactivity timeout: %ds · transient retries: %d\n", int(inactivityTimeout.Seconds()), transientRetries)
-	fmt.Println(strings.Repeat("─", 50))
+func (f resumeFilter) selectFolders(folders []EvalFolder) []int {
+	var indices []int
+	for i, ef := range folders {
+		if f.matches(ef) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
 
-	var results []EvalResult
-	if runMode == "parallel" {
-		results = runAllEvalsParallel(tasks, modelStr)
-	} else {
-		results = runAllEvalsSequential(tasks, modelStr)
+func (f resumeFilter) matches(ef EvalFolder) bool {
+	if f.Failed || f.Incomplete || f.Flaky {
+		failed := ef.Result != nil && !ef.Result.Success
+		incomplete := ef.Result == nil
+		flaky := ef.Result != nil && ef.Result.History.flaky()
+		if !(f.Failed && failed) && !(f.Incomplete && incomplete) && !(f.Flaky && flaky) {
+			return false
+		}
+	}
+	if len(f.Failures) > 0 && (ef.Result == nil || !containsFailure(f.Failures, ef.Result.Failure)) {
+		return false
+	}
+	if len(f.Models) > 0 && (ef.Result == nil || !containsString(f.Models, ef.Result.Model)) {
+		return false
+	}
+	if len(f.Prompts) > 0 && !containsInt(f.Prompts, ef.PromptNumber) {
+		return false
 	}
+	if !f.Since.IsZero() {
+		started, ok := evalFolderTime(ef)
+		if !ok || started.Before(f.Since) {
+			return false
+		}
+	}
+	if f.FoldersGlob != "" {
+		pathMatch, _ := filepath.Match(f.FoldersGlob, ef.Path)
+		baseMatch, _ := filepath.Match(f.FoldersGlob, filepath.Base(ef.Path))
+		if !pathMatch && !baseMatch {
+			return false
+		}
+	}
+	return true
+}
 
-	fmt.Printf("\n%s\n", strings.Repeat("═", 50))
-	fmt.Println("SUMMARY")
-	fmt.Println(strings.Repeat("═", 50))
+func containsFailure(categories []FailureCategory, c FailureCategory) bool {
+	for _, category := range categories {
+		if category == c {
+			return true
+		}
+	}
+	return false
+}
 
-	for _, result := range results {
-		status := "✓"
-		if !result.Success {
-			status = "✗"
+// evalFolderTime returns when an eval started, from the timestamp prefix of its
+// folder name, falling back to completed_at.
+func evalFolderTime(ef EvalFolder) (time.Time, bool) {
+	name := filepath.Base(ef.Path)
+	if len(name) >= len("2006-01-02_15-04-05") {
+		if t, err := time.ParseInLocation("2006-01-02_15-04-05", name[:len("2006-01-02_15-04-05")], time.Local); err == nil {
+			return t, true
 		}
-		fmt.Printf("%s [%ds] %s\n", status, int(result.Duration.Seconds()), result.Folder)
-		if result.Error != "" {
-			fmt.Printf("  Error: %s\n", result.Error)
+	}
+	if ef.Result != nil && ef.Result.CompletedAt != "" {
+		if t, err := time.Parse(time.RFC3339, ef.Result.CompletedAt); err == nil {
+			return t, true
 		}
 	}
+	return time.Time{}, false
+}
 
-	successful := 0
-	for _, r := range results {
-		if r.Success {
-			successful++
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
 	}
-	fmt.Printf("\n%d/%d evals completed successfully\n", successful, len(results))
+	return false
 }
 
 func fetchProviders(client *http.Client, baseURL string) (ProvidersData, error) {
@@ -1169,10 +2986,20 @@ func getProvidersData() (ProvidersData, error) {
 
 	cmd := exec.Command("opencode", "--port", fmt.Sprintf("%d", basePort))
 	cmd.Dir = "."
+	// Like an eval server, it gets its own process group so that stopping it
+	// (or a forced exit) also kills the processes it spawned.
+	setNewProcessGroup(cmd)
 	if err := cmd.Start(); err != nil {
 		return ProvidersData{}, fmt.Errorf("starting opencode: %w", err)
 	}
-	defer cmd.Process.Kill()
+	trackAgentProcess(cmd.Process)
+	stopKillOnInterrupt := killAgentsOnInterrupt()
+	defer func() {
+		stopKillOnInterrupt()
+		_ = signalProcessGroup(cmd.Process.Pid, os.Kill)
+		_ = cmd.Wait()
+		untrackAgentProcess(cmd.Process)
+	}()
 
 	if err := waitForProvidersEndpoint(client, baseURL, 5*time.Second); err != nil {
 		return ProvidersData{}, fmt.Errorf("waiting for opencode server: %w", err)
@@ -1774,14 +3601,18 @@ func sanitizeModelForFolder(model string) string {
 	return sanitized
 }
 
-func createTimestampFolder(index, promptNumber int, model string) string {
+func createTimestampFolder(index, promptNumber, trial int, model string) string {
 	now := time.Now()
 	if promptNumber < 1 {
 		promptNumber = 0
 	}
-	return fmt.Sprintf("evals/%d-%02d-%02d_%02d-%02d-%02d_p%d_%d_%s",
+	trialPart := ""
+	if trial > 0 {
+		trialPart = fmt.Sprintf("_t%d", trial)
+	}
+	return fmt.Sprintf("evals/%d-%02d-%02d_%02d-%02d-%02d_p%d%s_%d_%s",
 		now.Year(), now.Month(), now.Day(),
-		now.Hour(), now.Minute(), now.Second(), promptNumber, index, sanitizeModelForFolder(model))
+		now.Hour(), now.Minute(), now.Second(), promptNumber, trialPart, index, sanitizeModelForFolder(model))
 }
 
 func parsePromptNumberFromFolder(folderName string) int {
@@ -1799,21 +3630,21 @@ func parsePromptNumberFromFolder(folderName string) int {
 
 func buildPromptNumberByPrompt() map[string]int {
 	prompts, err := loadPrompts()
-	if err != nil || len(prompts) == 0 {
+	if err != nil || len(prompts.Prompts) == 0 {
 		return map[string]int{}
 	}
 
-	m := make(map[string]int, len(prompts))
-	for i, p := range prompts {
-		if _, exists := m[p]; exists {
+	m := make(map[string]int, len(prompts.Prompts))
+	for _, p := range prompts.Prompts {
+		if _, exists := m[p.Text]; exists {
 			continue
 		}
-		m[p] = i + 1
+		m[p.Text] = p.ID
 	}
 	return m
 }
 
-func setupEvalFolder(folderPath, prompt string) error {
+func setupEvalFolder(folderPath, prompt, rendered string) error {
 	if err := os.MkdirAll(folderPath, 0755); err != nil {
 		return err
 	}
@@ -1828,22 +3659,292 @@ func setupEvalFolder(folderPath, prompt string) error {
 		return err
 	}
 
-	if err := os.WriteFile(filepath.Join(folderPath, "prompt.txt"), []byte(prompt), 0644); err != nil {
+	return writePromptFiles(folderPath, prompt, rendered)
+}
+
+func writePromptFiles(folderPath, prompt, rendered string) error {
+	if err := os.WriteFile(filepath.Join(folderPath, "prompt.txt"), []byte(rendered), 0644); err != nil {
 		return err
 	}
+	return os.WriteFile(filepath.Join(folderPath, promptTemplateFile), []byte(prompt), 0644)
+}
+
+// archiveEvalWorkspace moves everything an earlier attempt left in folderPath
+// into <folderPath>.attempts/<N>/, where N is the attempt that produced it.
+// The archive lives outside the workspace so the next agent and its grader
+// cannot see the previous solution. result.json and the attempts directory
+// stay in place so the history keeps accumulating.
+func archiveEvalWorkspace(folderPath string) (string, error) {
+	records, err := loadAttempts(folderPath)
+	if err != nil {
+		return "", err
+	}
+	n := 0
+	if len(records) > 0 {
+		n = records[len(records)-1].Attempt
+	} else if _, err := os.Stat(filepath.Join(folderPath, "result.json")); err == nil {
+		n = 1
+	}
+
+	base := filepath.Join(filepath.Clean(folderPath)+workspaceArchiveSuffix, fmt.Sprintf("%03d", n))
+	archive := base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(archive); os.IsNotExist(err) {
+			break
+		}
+		archive = fmt.Sprintf("%s-%d", base, i)
+	}
+	if err := os.MkdirAll(archive, 0755); err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(folderPath)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.Name() == attemptsDir || entry.Name() == "result.json" {
+			continue
+		}
+		if err := os.Rename(filepath.Join(folderPath, entry.Name()), filepath.Join(archive, entry.Name())); err != nil {
+			return "", err
+		}
+	}
+	return archive, nil
+}
+
+type modelFlags []string
+
+func (m *modelFlags) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *modelFlags) Set(value string) error {
+	*m = appendModels(*m, value)
+	return nil
+}
+
+// appendModels adds the comma-separated models in value, skipping blanks and
+// duplicates.
+func appendModels(models []string, value string) []string {
+	for _, model := range strings.Split(value, ",") {
+		model = strings.TrimSpace(model)
+		if model == "" || containsString(models, model) {
+			continue
+		}
+		models = append(models, model)
+	}
+	return models
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type varFlags []string
+
+func (v *varFlags) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *varFlags) Set(value string) error {
+	*v = append(*v, value)
+	return nil
+}
+
+// redacted lists the variables without their values, which often hold tokens
+// or URLs with credentials.
+func (v *varFlags) redacted() string {
+	out := make([]string, len(*v))
+	for i, kv := range *v {
+		out[i] = redactVar(kv)
+	}
+	return strings.Join(out, ",")
+}
+
+func redactVar(kv string) string {
+	if key, _, ok := strings.Cut(kv, "="); ok {
+		return key + "=<redacted>"
+	}
+	return kv
+}
+
+// redactVarArgs returns a copy of args with the values of --var flags redacted.
+func redactVarArgs(args []string) []string {
+	out := append([]string(nil), args...)
+	for i, arg := range out {
+		switch {
+		case (arg == "-var" || arg == "--var") && i+1 < len(out):
+			out[i+1] = redactVar(out[i+1])
+		case strings.HasPrefix(arg, "-var=") || strings.HasPrefix(arg, "--var="):
+			name, value, _ := strings.Cut(arg, "=")
+			out[i] = name + "=" + redactVar(value)
+		}
+	}
+	return out
+}
+
+// applyPromptVars sets promptVars from base (suite vars), then varsFile, then
+// --var assignments, later sources winning.
+func applyPromptVars(base map[string]string, varsFile string, assignments []string) error {
+	vars := make(map[string]string)
+	for key, value := range base {
+		vars[key] = value
+	}
+
+	if varsFile != "" {
+		data, err := os.ReadFile(varsFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &vars); err != nil {
+			return fmt.Errorf("parsing %s: %w", varsFile, err)
+		}
+	}
+
+	for _, assignment := range assignments {
+		key, value, ok := strings.Cut(assignment, "=")
+		if !ok {
+			return fmt.Errorf("invalid --var %q (expected KEY=VALUE)", assignment)
+		}
+		vars[strings.TrimSpace(key)] = value
+	}
+
+	for key := range vars {
+		if !placeholderRE.MatchString("<" + key + ">") {
+			return fmt.Errorf("invalid variable name %q (use upper-case letters, digits and underscores)", key)
+		}
+		if isBuiltinPromptVar(key) {
+			return fmt.Errorf("variable %s is built in and cannot be overridden", key)
+		}
+	}
 
+	promptVars = vars
 	return nil
 }
 
+func isBuiltinPromptVar(name string) bool {
+	for _, builtin := range builtinPromptVarNames {
+		if name == builtin {
+			return true
+		}
+	}
+	return false
+}
+
+func builtinPromptVars(model string, promptNumber int, folderPath string, startedAt time.Time) map[string]string {
+	evalFolder := folderPath
+	if abs, err := filepath.Abs(folderPath); err == nil {
+		evalFolder = abs
+	}
+	return map[string]string{
+		"MODEL_NAME":    model,
+		"MODEL_ID":      model,
+		"PROMPT_NUMBER": strconv.Itoa(promptNumber),
+		"EVAL_FOLDER":   evalFolder,
+		"TIMESTAMP":     startedAt.Format(time.RFC3339),
+	}
+}
+
+func promptPlaceholders(prompt string) []string {
+	seen := make(map[string]struct{})
+	names := make([]string, 0)
+	for _, m := range placeholderRE.FindAllStringSubmatch(prompt, -1) {
+		if _, exists := seen[m[1]]; exists {
+			continue
+		}
+		seen[m[1]] = struct{}{}
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// detectPromptVars lists the placeholders in prompt that are not built in,
+// used to declare the variables of new and migrated prompts.
+func detectPromptVars(prompt string) []string {
+	var names []string
+	for _, name := range promptPlaceholders(prompt) {
+		if !isBuiltinPromptVar(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func missingPromptVars(prompt string, declared []string, vars map[string]string) []string {
+	missing := make([]string, 0)
+	for _, name := range promptPlaceholders(prompt) {
+		if _, ok := vars[name]; ok || isBuiltinPromptVar(name) || !containsString(declared, name) {
+			continue
+		}
+		missing = append(missing, name)
+	}
+	return missing
+}
+
+func checkPromptVars(tasks []EvalTask) error {
+	problems := make([]string, 0)
+	for _, t := range tasks {
+		missing := missingPromptVars(t.Prompt, t.Vars, promptVars)
+		if len(missing) == 0 {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("prompt #%d needs %s", t.PromptNumber, strings.Join(missing, ", ")))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("missing prompt variables: %s (set them with --var KEY=VALUE or --vars-file)", strings.Join(problems, "; "))
+}
+
+// renderPrompt fills in every placeholder that has a value. Declared
+// variables must have one; other placeholders are left as written.
+func renderPrompt(prompt string, declared []string, vars map[string]string) (string, error) {
+	if missing := missingPromptVars(prompt, declared, vars); len(missing) > 0 {
+		return "", fmt.Errorf("no value for %s", strings.Join(missing, ", "))
+	}
+	return placeholderRE.ReplaceAllStringFunc(prompt, func(match string) string {
+		if value, ok := vars[match[1:len(match)-1]]; ok {
+			return value
+		}
+		return match
+	}), nil
+}
+
 func saveEvalResult(folderPath string, result EvalResult, model string) {
 	rf := EvalResultFile{
 		Prompt:          result.Prompt,
 		PromptNumber:    result.PromptNumber,
 		Model:           model,
 		Success:         result.Success,
+		AgentCompleted:  result.AgentCompleted,
+		GradedPass:      result.GradedPass,
+		GraderStdout:    result.GraderStdout,
+		GraderStderr:    result.GraderStderr,
 		Error:           result.Error,
 		DurationSeconds: int(result.Duration.Seconds()),
 		CompletedAt:     time.Now().Format(time.RFC3339),
+		CostUSD:         result.CostUSD,
+		Attempts:        result.Attempts,
+		Trial:           result.Trial,
+		Suite:           result.Suite,
+		RunID:           result.RunID,
+		Failure:         result.Failure,
+		Port:            result.Port,
+	}
+	if !result.Tokens.isZero() {
+		tokens := result.Tokens
+		rf.Tokens = &tokens
+	}
+	if history, err := appendAttempt(folderPath, rf); err != nil {
+		logf("Warning: could not record attempt in %s: %v\n", folderPath, err)
+	} else {
+		rf.History = &history
 	}
 	data, err := json.MarshalIndent(rf, "", "  ")
 	if err != nil {
@@ -1852,6 +3953,139 @@ func saveEvalResult(folderPath string, result EvalResult, model string) {
 	_ = os.WriteFile(filepath.Join(folderPath, "result.json"), data, 0644)
 }
 
+// AttemptRecord is one finished attempt of an eval, kept in
+// attempts/NNN.json next to result.json.
+type AttemptRecord struct {
+	Attempt         int             `json:"attempt"`
+	RunID           string          `json:"run_id,omitempty"`
+	Model           string          `json:"model"`
+	Success         bool            `json:"success"`
+	Error           string          `json:"error,omitempty"`
+	Failure         FailureCategory `json:"failure,omitempty"`
+	DurationSeconds int             `json:"duration_seconds"`
+	Retry           int             `json:"retry"`
+	CompletedAt     string          `json:"completed_at"`
+	CostUSD         float64         `json:"cost_usd,omitempty"`
+}
+
+// AttemptHistory counts the attempts recorded for an eval folder. A try that
+// was retried after a transient failure is recorded but not counted, so every
+// run or resume counts once, by the outcome of its last try.
+type AttemptHistory struct {
+	Attempts int `json:"attempts"`
+	Passed   int `json:"passed"`
+	Failed   int `json:"failed"`
+}
+
+func (h *AttemptHistory) flaky() bool {
+	return h != nil && h.Passed > 0 && h.Failed > 0
+}
+
+func newAttemptRecord(attempt int, rf EvalResultFile) AttemptRecord {
+	return AttemptRecord{
+		Attempt:         attempt,
+		RunID:           rf.RunID,
+		Model:           rf.Model,
+		Success:         rf.Success,
+		Error:           rf.Error,
+		Failure:         rf.Failure,
+		DurationSeconds: rf.DurationSeconds,
+		Retry:           max(rf.Attempts-1, 0),
+		CompletedAt:     rf.CompletedAt,
+		CostUSD:         rf.CostUSD,
+	}
+}
+
+// appendAttempt records rf as the next attempt of the folder. A result.json
+// written before attempt history existed becomes attempt 1.
+func appendAttempt(folderPath string, rf EvalResultFile) (AttemptHistory, error) {
+	records, err := loadAttempts(folderPath)
+	if err != nil {
+		return AttemptHistory{}, err
+	}
+	if len(records) == 0 {
+		if data, err := os.ReadFile(filepath.Join(folderPath, "result.json")); err == nil {
+			var previous EvalResultFile
+			if json.Unmarshal(data, &previous) == nil {
+				if !previous.Success && previous.Failure == "" {
+					previous.Failure = legacyFailureCategory(previous)
+				}
+				records = append(records, newAttemptRecord(1, previous))
+				if err := writeAttempt(folderPath, records[0]); err != nil {
+					return AttemptHistory{}, err
+				}
+			}
+		}
+	}
+
+	next := 1
+	if n := len(records); n > 0 {
+		next = records[n-1].Attempt + 1
+	}
+	rec := newAttemptRecord(next, rf)
+	if err := writeAttempt(folderPath, rec); err != nil {
+		return AttemptHistory{}, err
+	}
+	records = append(records, rec)
+	return attemptHistory(records), nil
+}
+
+func attemptHistory(records []AttemptRecord) AttemptHistory {
+	var history AttemptHistory
+	for i, r := range records {
+		if i+1 < len(records) && records[i+1].Retry > 0 && records[i+1].RunID == r.RunID {
+			continue
+		}
+		history.Attempts++
+		if r.Success {
+			history.Passed++
+		} else {
+			history.Failed++
+		}
+	}
+	return history
+}
+
+func loadAttempts(folderPath string) ([]AttemptRecord, error) {
+	entries, err := os.ReadDir(filepath.Join(folderPath, attemptsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var records []AttemptRecord
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(folderPath, attemptsDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var rec AttemptRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", entry.Name(), err)
+		}
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Attempt < records[j].Attempt })
+	return records, nil
+}
+
+func writeAttempt(folderPath string, rec AttemptRecord) error {
+	dir := filepath.Join(folderPath, attemptsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, fmt.Sprintf("%03d.json", rec.Attempt)), data, 0644)
+}
+
 func scanEvalFolders() ([]EvalFolder, error) {
 	entries, err := os.ReadDir("evals")
 	if err != nil {
@@ -1864,116 +4098,352 @@ func scanEvalFolders() ([]EvalFolder, error) {
 	promptNumberByText := buildPromptNumberByPrompt()
 	var folders []EvalFolder
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		if !entry.IsDir() || strings.HasSuffix(entry.Name(), workspaceArchiveSuffix) {
 			continue
 		}
-		path := filepath.Join("evals", entry.Name())
-
-		promptData, err := os.ReadFile(filepath.Join(path, "prompt.txt"))
-		if err != nil {
-			continue
+		if ef, ok := readEvalFolder(filepath.Join("evals", entry.Name()), promptNumberByText); ok {
+			folders = append(folders, ef)
 		}
+	}
+
+	return folders, nil
+}
 
-		ef := EvalFolder{
-			Path:   path,
-			Prompt: string(promptData),
+// readEvalFolder loads one eval folder. It reports false for directories
+// without a prompt file; Result stays nil until the eval has finished.
+func readEvalFolder(path string, promptNumberByText map[string]int) (EvalFolder, bool) {
+	promptData, err := os.ReadFile(filepath.Join(path, promptTemplateFile))
+	if err != nil {
+		promptData, err = os.ReadFile(filepath.Join(path, "prompt.txt"))
+		if err != nil {
+			return EvalFolder{}, false
 		}
+	}
 
-		resultData, err := os.ReadFile(filepath.Join(path, "result.json"))
-		if err == nil {
-			var rf EvalResultFile
-			if json.Unmarshal(resultData, &rf) == nil {
-				ef.Result = &rf
-				if rf.PromptNumber > 0 {
-					ef.PromptNumber = rf.PromptNumber
-				}
+	ef := EvalFolder{
+		Path:   path,
+		Prompt: string(promptData),
+	}
+
+	resultData, err := os.ReadFile(filepath.Join(path, "result.json"))
+	if err == nil {
+		var rf EvalResultFile
+		if json.Unmarshal(resultData, &rf) == nil {
+			if !rf.Success && rf.Failure == "" {
+				rf.Failure = legacyFailureCategory(rf)
 			}
-		}
-		if ef.PromptNumber == 0 {
-			ef.PromptNumber = parsePromptNumberFromFolder(filepath.Base(path))
-		}
-		if ef.PromptNumber == 0 {
-			if n, ok := promptNumberByText[ef.Prompt]; ok {
-				ef.PromptNumber = n
+			ef.Result = &rf
+			if rf.PromptNumber > 0 {
+				ef.PromptNumber = rf.PromptNumber
 			}
 		}
+	}
+	if ef.PromptNumber == 0 {
+		ef.PromptNumber = parsePromptNumberFromFolder(filepath.Base(path))
+	}
+	if ef.PromptNumber == 0 {
+		if n, ok := promptNumberByText[ef.Prompt]; ok {
+			ef.PromptNumber = n
+		}
+	}
+	return ef, true
+}
+
+// EvalResultOutput is the machine-readable form of an EvalResult used by
+// --output json and jsonl.
+type EvalResultOutput struct {
+	Folder          string          `json:"folder"`
+	Prompt          string          `json:"prompt"`
+	PromptNumber    int             `json:"prompt_number"`
+	Model           string          `json:"model"`
+	Success         bool            `json:"success"`
+	AgentCompleted  bool            `json:"agent_completed"`
+	GradedPass      *bool           `json:"graded_pass,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	CostUSD         float64         `json:"cost_usd"`
+	Tokens          *TokenUsage     `json:"tokens,omitempty"`
+	Attempts        int             `json:"attempts"`
+	Trial           int             `json:"trial,omitempty"`
+	Suite           *SuiteRef       `json:"suite,omitempty"`
+	RunID           string          `json:"run_id,omitempty"`
+	Failure         FailureCategory `json:"failure,omitempty"`
+	Port            int             `json:"port,omitempty"`
+}
+
+func newEvalResultOutput(r EvalResult) EvalResultOutput {
+	out := EvalResultOutput{
+		Folder:          r.Folder,
+		Prompt:          r.Prompt,
+		PromptNumber:    r.PromptNumber,
+		Model:           r.Model,
+		Success:         r.Success,
+		AgentCompleted:  r.AgentCompleted,
+		GradedPass:      r.GradedPass,
+		Error:           r.Error,
+		DurationSeconds: r.Duration.Seconds(),
+		CostUSD:         r.CostUSD,
+		Attempts:        r.Attempts,
+		Trial:           r.Trial,
+		Suite:           r.Suite,
+		RunID:           r.RunID,
+		Failure:         r.Failure,
+		Port:            r.Port,
+	}
+	if !r.Tokens.isZero() {
+		tokens := r.Tokens
+		out.Tokens = &tokens
+	}
+	return out
+}
+
+// TaskEvent is one line of --output jsonl, emitted whenever a task changes
+// state: queued, started, retrying or finished.
+type TaskEvent struct {
+	Event        string            `json:"event"`
+	Time         string            `json:"time"`
+	Index        int               `json:"index"`
+	PromptNumber int               `json:"prompt_number"`
+	Model        string            `json:"model"`
+	Folder       string            `json:"folder,omitempty"`
+	Trial        int               `json:"trial,omitempty"`
+	RunID        string            `json:"run_id,omitempty"`
+	Attempt      int               `json:"attempt,omitempty"`
+	Result       *EvalResultOutput `json:"result,omitempty"`
+}
+
+var taskEventMu sync.Mutex
 
-		folders = append(folders, ef)
+func emitTaskEvent(event string, index int, task EvalTask, mutate func(*TaskEvent)) {
+	if outputMode != outputJSONL {
+		return
 	}
+	ev := TaskEvent{
+		Event:        event,
+		Time:         time.Now().Format(time.RFC3339Nano),
+		Index:        index,
+		PromptNumber: task.PromptNumber,
+		Model:        task.Model,
+		Folder:       task.Folder,
+		Trial:        task.Trial,
+		RunID:        task.RunID,
+	}
+	if mutate != nil {
+		mutate(&ev)
+	}
+
+	taskEventMu.Lock()
+	defer taskEventMu.Unlock()
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(ev)
+}
 
-	return folders, nil
+func emitTaskFinished(index int, task EvalTask, result EvalResult) {
+	emitTaskEvent("finished", index, task, func(ev *TaskEvent) {
+		out := newEvalResultOutput(result)
+		ev.Model = result.Model
+		ev.Folder = result.Folder
+		ev.Result = &out
+	})
 }
 
 type EvalTask struct {
 	Prompt       string
 	PromptNumber int
+	Model        string
 	Folder       string // empty = create new folder
+	Grader       *Grader
+	// InactivityTimeout overrides the global inactivity timeout when set.
+	InactivityTimeout time.Duration
+	// Attempt is the 1-based transient retry attempt this run belongs to.
+	Attempt int
+	// Trial is the 1-based trial index with --trials > 1, otherwise 0.
+	Trial int
+	Suite *SuiteRef
+	RunID string
+	// Vars are the user variables the prompt declares; see PromptRecord.
+	Vars []string
+	// Fresh archives an existing folder's contents and restores the scaffold
+	// before the run instead of building on the previous state.
+	Fresh bool
 }
 
-func runAllEvalsParallel(tasks []EvalTask, model string) []EvalResult {
-	var wg sync.WaitGroup
+// portAllocator hands out free loopback ports for agent servers. A port stays
+// reserved until it is released so concurrent evals never get the same one.
+type portAllocator struct {
+	mu       sync.Mutex
+	reserved map[int]bool
+}
+
+var agentPorts = &portAllocator{reserved: make(map[int]bool)}
+
+func (a *portAllocator) allocate() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := 0; i < 20; i++ {
+		port, err := freePort()
+		if err != nil {
+			return 0, err
+		}
+		if !a.reserved[port] {
+			a.reserved[port] = true
+			return port, nil
+		}
+	}
+	return 0, errors.New("every port offered by the OS is already reserved by this run")
+}
+
+func (a *portAllocator) release(port int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.reserved, port)
+}
+
+// freePort asks the OS for an unused loopback port. The probe listener is
+// closed again so the agent server can bind to it.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// runAllEvalsParallel runs tasks on up to concurrency workers. A concurrency
+// below 1 runs every task at once.
+func runAllEvalsParallel(ctx context.Context, tasks []EvalTask, concurrency int) []EvalResult {
+	if concurrency < 1 || concurrency > len(tasks) {
+		concurrency = len(tasks)
+	}
+
 	results := make([]EvalResult, len(tasks))
-	resultMutex := &sync.Mutex{}
 
-	for i, task := range tasks {
+	queue := make(chan int, len(tasks))
+	for i := range tasks {
+		queue <- i
+		emitTaskEvent("queued", i, tasks[i], nil)
+	}
+	close(queue)
+
+	if concurrency < len(tasks) {
+		logf("Running %d at a time, %d queued\n", concurrency, len(tasks)-concurrency)
+		for i := concurrency; i < len(tasks); i++ {
+			logf("[%d] Pending: p%d %s\n", i, tasks[i].PromptNumber, tasks[i].Model)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
 		wg.Add(1)
-		go func(index int, t EvalTask) {
+		go func() {
 			defer wg.Done()
-			result := runAgentWithRetry(t.Prompt, t.PromptNumber, index, model, t.Folder)
-			resultMutex.Lock()
-			results[index] = result
-			resultMutex.Unlock()
-		}(i, task)
+			for index := range queue {
+				if ctx.Err() != nil {
+					results[index] = notStartedResult(tasks[index], "run interrupted")
+					emitTaskFinished(index, tasks[index], results[index])
+					continue
+				}
+				results[index] = runAgentWithRetry(ctx, tasks[index], index)
+				emitTaskFinished(index, tasks[index], results[index])
+			}
+		}()
 	}
 
 	wg.Wait()
 	return results
 }
 
-func runAllEvalsSequential(tasks []EvalTask, model string) []EvalResult {
+func runAllEvalsSequential(ctx context.Context, tasks []EvalTask) []EvalResult {
 	results := make([]EvalResult, len(tasks))
-	currentModel := model
+	// Corrections picked after a model-not-found error apply to every later
+	// task that requested the same model.
+	corrections := make(map[string]string)
+
+	for i, task := range tasks {
+		emitTaskEvent("queued", i, task, nil)
+	}
 
+	aborted := false
 	for i, task := range tasks {
-		results[i] = runAgentWithRetry(task.Prompt, task.PromptNumber, i, currentModel, task.Folder)
+		if ctx.Err() != nil || aborted {
+			reason := "run interrupted"
+			if aborted {
+				reason = "remaining evals aborted"
+			}
+			results[i] = notStartedResult(task, reason)
+			emitTaskFinished(i, task, results[i])
+			continue
+		}
+		requestedModel := task.Model
+		if corrected, ok := corrections[requestedModel]; ok {
+			task.Model = corrected
+		}
+		results[i] = runAgentWithRetry(ctx, task, i)
+		emitTaskFinished(i, task, results[i])
 
 		// On model-not-found, prompt user to correct and re-run this eval
-		if !results[i].Success {
-			isModelErr, suggestions := isModelNotFoundError(results[i].Error)
-			if isModelErr {
-				fmt.Printf("\n[%d] Model not found: %s\n", i, currentModel)
-				corrected, correctionAborted := promptModelCorrection(currentModel, suggestions)
-				if correctionAborted || corrected == "" {
-					fmt.Println("No model selected, aborting remaining evals.")
-					return results
+		if results[i].Failure == failureModelNotFound && ctx.Err() == nil {
+			suggestions := modelSuggestions(results[i].Error)
+			logf("\n[%d] Model not found: %s\n", i, task.Model)
+			if !interactiveSession {
+				if len(suggestions) > 0 {
+					logf("[%d] Did you mean: %s\n", i, strings.Join(suggestions, ", "))
 				}
-				currentModel = corrected
-				fmt.Printf("[%d] Retrying with model: %s\n", i, currentModel)
-				results[i] = runAgentWithRetry(task.Prompt, task.PromptNumber, i, currentModel, task.Folder)
+				continue
+			}
+			corrected, correctionAborted := promptModelCorrection(task.Model, suggestions)
+			if correctionAborted || corrected == "" {
+				logf("No model selected, aborting remaining evals.\n")
+				aborted = true
+				continue
 			}
+			corrections[requestedModel] = corrected
+			task.Model = corrected
+			logf("[%d] Retrying with model: %s\n", i, task.Model)
+			results[i] = runAgentWithRetry(ctx, task, i)
+			emitTaskFinished(i, task, results[i])
 		}
 	}
 	return results
 }
 
-func runAgentWithRetry(prompt string, promptNumber, index int, modelStr string, existingFolder string) EvalResult {
+// notStartedResult is the result of a task that was never scheduled because
+// the run was interrupted or aborted first.
+func notStartedResult(task EvalTask, reason string) EvalResult {
+	return EvalResult{
+		Prompt:       task.Prompt,
+		PromptNumber: task.PromptNumber,
+		Model:        task.Model,
+		Folder:       task.Folder,
+		Error:        "not started: " + reason,
+		Failure:      failureInterrupted,
+		Trial:        task.Trial,
+		Suite:        task.Suite,
+		RunID:        task.RunID,
+	}
+}
+
+func runAgentWithRetry(ctx context.Context, task EvalTask, index int) EvalResult {
 	maxAttempts := transientRetries + 1
 	if maxAttempts < 1 {
 		maxAttempts = 1
 	}
 
-	folder := existingFolder
 	var result EvalResult
 
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
 		if attempt > 1 {
-			fmt.Printf("[%d] Retry attempt %d/%d after transient failure\n", index, attempt-1, transientRetries)
+			logf("[%d] Retry attempt %d/%d after transient failure\n", index, attempt-1, transientRetries)
+			emitTaskEvent("retrying", index, task, func(ev *TaskEvent) { ev.Attempt = attempt })
 		}
 
-		result = runAgent(prompt, promptNumber, index, modelStr, folder)
-		folder = result.Folder
+		task.Attempt = attempt
+		result = runAgent(ctx, task, index)
+		task.Folder = result.Folder
 
-		if result.Success || !isTransientEvalError(result.Error) || attempt == maxAttempts {
+		if result.Success || !result.Failure.transient() || attempt == maxAttempts {
 			return result
 		}
 	}
@@ -1981,106 +4451,527 @@ func runAgentWithRetry(prompt string, promptNumber, index int, modelStr string,
 	return result
 }
 
-func runAgent(prompt string, promptNumber, index int, modelStr string, existingFolder string) EvalResult {
+func runAgent(ctx context.Context, task EvalTask, index int) EvalResult {
 	startTime := time.Now()
+	prompt, promptNumber, modelStr := task.Prompt, task.PromptNumber, task.Model
+	existingFolder := task.Folder
 
 	folderPath := existingFolder
 	if folderPath == "" {
-		folderPath = createTimestampFolder(index, promptNumber, modelStr)
+		folderPath = createTimestampFolder(index, promptNumber, task.Trial, modelStr)
+		activeManifest.recordFolder(index, folderPath)
 	} else if promptNumber < 1 {
 		promptNumber = parsePromptNumberFromFolder(filepath.Base(folderPath))
 	}
 
-	fmt.Printf("[%d] Starting eval in %s\n", index, folderPath)
+	logf("[%d] Starting eval in %s\n", index, folderPath)
+	emitTaskEvent("started", index, task, func(ev *TaskEvent) { ev.Folder = folderPath })
 
 	result := EvalResult{
 		Prompt:       prompt,
 		PromptNumber: promptNumber,
+		Model:        modelStr,
 		Folder:       folderPath,
 		Success:      false,
 		Duration:     0,
+		Attempts:     max(task.Attempt, 1),
+		Trial:        task.Trial,
+		Suite:        task.Suite,
+		RunID:        task.RunID,
 	}
 
-	if existingFolder == "" {
-		if err := setupEvalFolder(folderPath, prompt); err != nil {
-			result.Error = fmt.Sprintf("Failed to setup folder: %v", err)
-			result.Duration = time.Since(startTime)
+	vars := builtinPromptVars(modelStr, promptNumber, folderPath, startTime)
+	for k, v := range promptVars {
+		vars[k] = v
+	}
+	rendered, err := renderPrompt(prompt, task.Vars, vars)
+	if err != nil {
+		result.Error = fmt.Sprintf("Failed to render prompt: %v", err)
+		result.Failure = failureSetup
+		result.Duration = time.Since(startTime)
+		if existingFolder != "" {
 			saveEvalResult(folderPath, result, modelStr)
-			return result
 		}
+		return result
 	}
 
-	port := basePort + index
-	providerID, modelID := parseModel(modelStr)
-
-	cmd := exec.Command("opencode", "--port", fmt.Sprintf("%d", port))
-	cmd.Dir = folderPath
-	if err := cmd.Start(); err != nil {
-		result.Error = fmt.Sprintf("Failed to start opencode: %v", err)
+	if existingFolder == "" {
+		err = setupEvalFolder(folderPath, prompt, rendered)
+	} else if task.Fresh {
+		var archive string
+		if archive, err = archiveEvalWorkspace(folderPath); err == nil {
+			logf("[%d] Archived previous workspace to %s\n", index, archive)
+			err = setupEvalFolder(folderPath, prompt, rendered)
+		}
+	} else {
+		err = writePromptFiles(folderPath, prompt, rendered)
+	}
+	if err != nil {
+		result.Error = fmt.Sprintf("Failed to setup folder: %v", err)
+		result.Failure = failureSetup
 		result.Duration = time.Since(startTime)
 		saveEvalResult(folderPath, result, modelStr)
 		return result
 	}
-	defer cmd.Process.Kill()
 
-	baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
-	client := &http.Client{Timeout: 10 * time.Second}
+	backend, err := newAgentBackend(agentBackendName)
+	if err != nil {
+		result.Error = err.Error()
+		result.Failure = failureSetup
+		result.Duration = time.Since(startTime)
+		saveEvalResult(folderPath, result, modelStr)
+		return result
+	}
 
-	// Wait for server to be ready by polling session creation
 	var session *Session
-	var sessionErr error
-	deadline := time.Now().Add(15 * time.Second)
-	for time.Now().Before(deadline) {
-		session, sessionErr = createSession(client, baseURL, fmt.Sprintf("Eval %d", index))
-		if sessionErr == nil {
+	for try := 1; session == nil; try++ {
+		port, err := agentPorts.allocate()
+		if err != nil {
+			result.Error = fmt.Sprintf("Could not allocate a free port for %s: %v", agentBackendName, err)
+			result.Failure = failureServerStart
+			result.Duration = time.Since(startTime)
+			saveEvalResult(folderPath, result, modelStr)
+			return result
+		}
+		result.Port = port
+		logf("[%d] Starting %s on port %d\n", index, agentBackendName, port)
+
+		if err := backend.Start(folderPath, port); err != nil {
+			agentPorts.release(port)
+			result.Error = fmt.Sprintf("Failed to start %s on port %d: %v", agentBackendName, port, err)
+			result.Failure = failureServerStart
+			result.Duration = time.Since(startTime)
+			saveEvalResult(folderPath, result, modelStr)
+			return result
+		}
+
+		var portLost bool
+		var sessionErr error
+		session, portLost, sessionErr = waitForServer(ctx, backend, index)
+		if session != nil {
+			defer agentPorts.release(port)
+			defer backend.Stop()
 			break
 		}
-		time.Sleep(500 * time.Millisecond)
-	}
-	if session == nil {
-		result.Error = fmt.Sprintf("Server not ready after 15s: %v", sessionErr)
+		backend.Stop()
+		agentPorts.release(port)
+
+		switch {
+		case ctx.Err() != nil:
+			result.Error = "interrupted"
+			result.Failure = failureInterrupted
+		case portLost && try < serverStartAttempts:
+			logf("[%d] %s on port %d: %v, retrying on a new port\n", index, agentBackendName, port, sessionErr)
+			continue
+		case portLost:
+			result.Error = fmt.Sprintf("%s on port %d: %v (tried %d ports)", agentBackendName, port, sessionErr, try)
+			result.Failure = failureServerStart
+		default:
+			result.Error = fmt.Sprintf("Server on port %d not ready after %s: %v", port, serverReadyTimeout, sessionErr)
+			result.Failure = failureSessionCreate
+		}
 		result.Duration = time.Since(startTime)
 		saveEvalResult(folderPath, result, modelStr)
 		return result
 	}
 
-	fmt.Printf("[%d] Session created: %s\n", index, session.ID)
+	logf("[%d] Session created: %s\n", index, session.ID)
 
 	// Subscribe to SSE events BEFORE sending the prompt to avoid race condition
-	eventResp, err := http.Get(baseURL + "/event")
+	eventStream, err := backend.StreamEvents()
 	if err != nil {
 		result.Error = fmt.Sprintf("Failed to subscribe to events: %v", err)
+		result.Failure = failureStream
 		result.Duration = time.Since(startTime)
 		saveEvalResult(folderPath, result, modelStr)
 		return result
 	}
-	defer eventResp.Body.Close()
+	defer eventStream.Close()
 
-	fmt.Printf("[%d] Sending prompt...\n", index)
+	logf("[%d] Sending prompt...\n", index)
 
-	if err := sendPrompt(client, baseURL, session.ID, providerID, modelID, prompt); err != nil {
+	if err := backend.SendPrompt(session.ID, modelStr, rendered); err != nil {
 		result.Error = fmt.Sprintf("Failed to send prompt: %v", err)
+		result.Failure = failureSendPrompt
 		result.Duration = time.Since(startTime)
 		saveEvalResult(folderPath, result, modelStr)
 		return result
 	}
 
-	completed, errMsg := waitForCompletion(eventResp.Body, session.ID, index)
+	usage := newSessionUsage(session.ID)
+	timeout := inactivityTimeout
+	if task.InactivityTimeout > 0 {
+		timeout = task.InactivityTimeout
+	}
+	var eventLog io.Writer
+	if f, err := os.OpenFile(filepath.Join(folderPath, "events.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		logf("[%d] Warning: could not open events.jsonl: %v\n", index, err)
+	} else {
+		defer f.Close()
+		eventLog = f
+	}
+	budget := evalBudget{Started: startTime, MaxDuration: maxEvalDuration, MaxCost: maxEvalCost}
+	completed, errMsg, failure := waitForCompletion(ctx, eventStream, session.ID, index, timeout, budget, usage, eventLog)
+	if failure == failureBudget {
+		if aborter, ok := backend.(sessionAborter); ok {
+			if err := aborter.Abort(session.ID); err != nil {
+				logf("[%d] Warning: could not abort session: %v\n", index, err)
+			}
+		}
+	}
+	if ctx.Err() != nil && !completed {
+		failure = failureInterrupted
+		if errMsg == "" {
+			errMsg = "interrupted"
+		}
+	}
 
 	result.Duration = time.Since(startTime)
-	fmt.Printf("[%d] Completed in %ds\n", index, int(result.Duration.Seconds()))
+	result.CostUSD, result.Tokens = usage.totals()
+	logf("[%d] Completed in %ds\n", index, int(result.Duration.Seconds()))
+
+	if source, ok := backend.(transcriptSource); ok {
+		if messages, err := source.Messages(session.ID); err != nil {
+			logf("[%d] Warning: could not fetch transcript: %v\n", index, err)
+		} else if err := saveTranscript(folderPath, session.ID, messages); err != nil {
+			logf("[%d] Warning: could not save transcript: %v\n", index, err)
+		}
+	}
 
-	result.Success = completed && errMsg == ""
+	result.AgentCompleted = completed && errMsg == ""
+	result.Success = result.AgentCompleted
 	if errMsg != "" {
 		result.Error = errMsg
+		result.Failure = failure
 	} else if !completed {
 		result.Error = "agent did not reach idle state"
+		result.Failure = failureStream
+	}
+
+	if result.AgentCompleted && task.Grader != nil && ctx.Err() != nil {
+		result.Success = false
+		result.Error = "interrupted before grading"
+		result.Failure = failureInterrupted
+	} else if result.AgentCompleted && task.Grader != nil {
+		logf("[%d] Grading...\n", index)
+		grade := gradeEval(ctx, folderPath, task.Grader)
+		result.GraderStdout = grade.Stdout
+		result.GraderStderr = grade.Stderr
+		result.Success = grade.Pass
+		if !grade.Interrupted {
+			result.GradedPass = &grade.Pass
+		}
+		if grade.Interrupted {
+			result.Error = "interrupted while grading"
+			result.Failure = failureInterrupted
+			logf("[%d] Grading interrupted\n", index)
+		} else if grade.Pass {
+			logf("[%d] Grader passed\n", index)
+		} else {
+			result.Error = "grader failed: " + grade.Reason
+			result.Failure = failureGrader
+			logf("[%d] Grader failed: %s\n", index, grade.Reason)
+		}
 	}
 
 	saveEvalResult(folderPath, result, modelStr)
 	return result
 }
 
+// waitForServer polls the backend until it accepts a session. portLost reports
+// a server that cannot serve its port: the process quit first, e.g. because
+// another process took the port, or the session came from another server.
+// Either way the caller should retry on a new port.
+func waitForServer(ctx context.Context, backend AgentBackend, index int) (session *Session, portLost bool, err error) {
+	var done <-chan struct{}
+	if w, ok := backend.(serverWatcher); ok {
+		done = w.Exited()
+	}
+	deadline := time.Now().Add(serverReadyTimeout)
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		session, err = backend.CreateSession(fmt.Sprintf("Eval %d", index))
+		select {
+		case <-done:
+			return nil, true, errors.New("exited during startup")
+		default:
+		}
+		if err == nil {
+			return session, false, nil
+		}
+		if errors.Is(err, errForeignServer) {
+			return nil, true, err
+		}
+		select {
+		case <-done:
+			return nil, true, errors.New("exited during startup")
+		case <-ctx.Done():
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return nil, false, err
+}
+
+// AgentBackend drives one coding agent for a single eval. StreamEvents must
+// return Server-Sent Events ("data: <json>" lines) using opencode's event
+// types, which is what waitForCompletion understands.
+type AgentBackend interface {
+	Start(dir string, port int) error
+	CreateSession(title string) (*Session, error)
+	SendPrompt(sessionID, model, prompt string) error
+	StreamEvents() (io.ReadCloser, error)
+	Stop() error
+}
+
+// transcriptSource is implemented by backends that can return the full
+// message history of a session after it finishes.
+type transcriptSource interface {
+	Messages(sessionID string) ([]TranscriptMessage, error)
+}
+
+// serverWatcher is implemented by backends that run a local server process.
+// The channel is closed once that process has exited.
+type serverWatcher interface {
+	Exited() <-chan struct{}
+}
+
+// sessionAborter is implemented by backends that can stop a running session,
+// used when an eval exceeds its budget.
+type sessionAborter interface {
+	Abort(sessionID string) error
+}
+
+var agentBackends = map[string]func() AgentBackend{
+	"opencode": func() AgentBackend { return &opencodeBackend{} },
+}
+
+func newAgentBackend(name string) (AgentBackend, error) {
+	factory, ok := agentBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown agent backend %q (available: %s)", name, strings.Join(agentBackendNames(), ", "))
+	}
+	return factory(), nil
+}
+
+func agentBackendNames() []string {
+	names := make([]string, 0, len(agentBackends))
+	for name := range agentBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// opencodeBackend runs `opencode --port` in the eval folder and talks to its
+// HTTP API. With attachURL set it uses an already running server instead.
+type opencodeBackend struct {
+	attachURL string
+	cmd       *exec.Cmd
+	exited    chan struct{}
+	dir       string
+	baseURL   string
+	client    *http.Client
+}
+
+func (b *opencodeBackend) Start(dir string, port int) error {
+	b.client = &http.Client{Timeout: 10 * time.Second}
+	if b.attachURL != "" {
+		b.baseURL = strings.TrimSuffix(b.attachURL, "/")
+		return nil
+	}
+
+	cmd := exec.Command("opencode", "--port", fmt.Sprintf("%d", port))
+	cmd.Dir = dir
+	// Its own process group keeps the terminal's Ctrl+C away from the server
+	// and lets Stop kill everything it spawned.
+	setNewProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	trackAgentProcess(cmd.Process)
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	if err := writeAgentPIDFile(dir, cmd.Process.Pid); err != nil {
+		logf("Warning: could not write %s: %v\n", agentPIDFile, err)
+	}
+	b.cmd = cmd
+	b.exited = exited
+	b.dir = dir
+	b.baseURL = fmt.Sprintf("http://127.0.0.1:%d", port)
+	return nil
+}
+
+// errForeignServer reports a session created by some other server that
+// answers on the port the eval's server was started on.
+var errForeignServer = errors.New("another server answered on its port")
+
+func (b *opencodeBackend) CreateSession(title string) (*Session, error) {
+	session, err := createSession(b.client, b.baseURL, title)
+	if err != nil || b.cmd == nil {
+		return session, err
+	}
+	// The server we started serves the eval folder; a session in any other
+	// directory came from a server that already held the port.
+	if !sameDirectory(session.Directory, b.dir) {
+		return nil, fmt.Errorf("%w (session directory %q)", errForeignServer, session.Directory)
+	}
+	return session, nil
+}
+
+func sameDirectory(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	resolve := func(path string) string {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			path = real
+		}
+		return filepath.Clean(path)
+	}
+	return resolve(a) == resolve(b)
+}
+
+func (b *opencodeBackend) SendPrompt(sessionID, model, prompt string) error {
+	providerID, modelID := parseModel(model)
+	return sendPrompt(b.client, b.baseURL, sessionID, providerID, modelID, prompt)
+}
+
+func (b *opencodeBackend) StreamEvents() (io.ReadCloser, error) {
+	resp, err := http.Get(b.baseURL + "/event")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *opencodeBackend) Messages(sessionID string) ([]TranscriptMessage, error) {
+	return fetchSessionMessages(b.client, b.baseURL, sessionID)
+}
+
+func (b *opencodeBackend) Abort(sessionID string) error {
+	return abortSession(b.client, b.baseURL, sessionID)
+}
+
+func (b *opencodeBackend) Exited() <-chan struct{} {
+	return b.exited
+}
+
+// Stop asks the server to shut down, waits up to agentStopGracePeriod and
+// then kills its whole process group so no child outlives the eval.
+func (b *opencodeBackend) Stop() error {
+	if b.cmd == nil || b.cmd.Process == nil {
+		return nil
+	}
+	pid := b.cmd.Process.Pid
+	defer untrackAgentProcess(b.cmd.Process)
+
+	if err := signalProcessGroup(pid, os.Interrupt); err == nil {
+		select {
+		case <-b.exited:
+		case <-time.After(agentStopGracePeriod):
+		}
+	}
+	_ = signalProcessGroup(pid, os.Kill)
+	<-b.exited
+
+	if err := os.Remove(filepath.Join(b.dir, agentPIDFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// agentProcesses holds the agent servers that are currently running so that a
+// forced exit can kill them instead of leaving them orphaned.
+var agentProcesses = struct {
+	sync.Mutex
+	running map[*os.Process]struct{}
+}{running: make(map[*os.Process]struct{})}
+
+func trackAgentProcess(p *os.Process) {
+	agentProcesses.Lock()
+	defer agentProcesses.Unlock()
+	agentProcesses.running[p] = struct{}{}
+}
+
+func untrackAgentProcess(p *os.Process) {
+	agentProcesses.Lock()
+	defer agentProcesses.Unlock()
+	delete(agentProcesses.running, p)
+}
+
+func killAgentProcesses() {
+	agentProcesses.Lock()
+	defer agentProcesses.Unlock()
+	for p := range agentProcesses.running {
+		_ = signalProcessGroup(p.Pid, os.Kill)
+	}
+}
+
+// interruptContext returns a context that is cancelled on the first Ctrl+C (or
+// SIGTERM): runners stop scheduling new evals and in-flight evals are recorded
+// as interrupted before their servers are stopped. A second signal kills the
+// remaining agent servers and exits immediately.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+
+	go func() {
+		select {
+		case <-signals:
+		case <-stopped:
+			return
+		}
+		logf("\nInterrupted: stopping evals and saving results (press Ctrl+C again to force quit)\n")
+		cancel()
+
+		select {
+		case <-signals:
+		case <-stopped:
+			return
+		}
+		logf("Force quitting: killing agent servers\n")
+		killAgentProcesses()
+		os.Exit(exitInterrupted)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(stopped)
+		cancel()
+	}
+}
+
+// killAgentsOnInterrupt kills the running agent servers and exits on Ctrl+C
+// (or SIGTERM) until the returned function is called. Servers run in their own
+// process group, so the terminal's signal would not reach them otherwise.
+func killAgentsOnInterrupt() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+
+	go func() {
+		select {
+		case <-signals:
+		case <-stopped:
+			return
+		}
+		killAgentProcesses()
+		os.Exit(exitInterrupted)
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(stopped)
+	}
+}
+
 func createSession(client *http.Client, baseURL, title string) (*Session, error) {
 	reqBody := map[string]string{"title": title}
 	body, _ := json.Marshal(reqBody)
@@ -2121,6 +5012,20 @@ func createSession(client *http.Client, baseURL, title string) (*Session, error)
 	return nil, fmt.Errorf("empty session ID in response: %s", string(respBody))
 }
 
+func abortSession(client *http.Client, baseURL, sessionID string) error {
+	resp, err := client.Post(fmt.Sprintf("%s/session/%s/abort", baseURL, sessionID), "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
 func sendPrompt(client *http.Client, baseURL, sessionID, providerID, modelID, prompt string) error {
 	reqBody := PromptRequest{
 		Model: Model{ProviderID: providerID, ModelID: modelID},
@@ -2147,8 +5052,30 @@ func sendPrompt(client *http.Client, baseURL, sessionID, providerID, modelID, pr
 	return nil
 }
 
-func waitForCompletion(eventStream io.ReadCloser, sessionID string, index int) (bool, string) {
+// evalBudget caps a single eval. Zero limits are not enforced.
+type evalBudget struct {
+	Started     time.Time
+	MaxDuration time.Duration
+	MaxCost     float64
+}
+
+// exceeded reports why the eval is over budget, or "" while it is within it.
+func (b evalBudget) exceeded(now time.Time, cost float64) string {
+	if b.MaxDuration > 0 && now.Sub(b.Started) > b.MaxDuration {
+		return fmt.Sprintf("budget exceeded: running for more than %s (--max-duration)", b.MaxDuration)
+	}
+	if b.MaxCost > 0 && cost > b.MaxCost {
+		return fmt.Sprintf("budget exceeded: cost %s is over %s (--max-cost)", formatCost(cost), formatCost(b.MaxCost))
+	}
+	return ""
+}
+
+// waitForCompletion follows the event stream until the session goes idle,
+// fails, stalls or runs over budget. A failed session also reports its
+// failure category.
+func waitForCompletion(ctx context.Context, eventStream io.ReadCloser, sessionID string, index int, timeout time.Duration, budget evalBudget, usage *sessionUsage, eventLog io.Writer) (bool, string, FailureCategory) {
 	completed := false
+	var failure FailureCategory
 	var errorMsg string
 	lastActivity := time.Now()
 	stateMu := sync.Mutex{}
@@ -2156,6 +5083,15 @@ func waitForCompletion(eventStream io.ReadCloser, sessionID string, index int) (
 	done := make(chan struct{})
 	var closeOnce sync.Once
 	closeDone := func() { closeOnce.Do(func() { close(done) }) }
+	stopOverBudget := func(reason string) (bool, string, FailureCategory) {
+		logf("[%d] Aborting: %s\n", index, reason)
+		stateMu.Lock()
+		errorMsg = reason
+		failure = failureBudget
+		stateMu.Unlock()
+		closeDone()
+		return false, reason, failureBudget
+	}
 
 	go func() {
 		ticker := time.NewTicker(1 * time.Second)
@@ -2164,17 +5100,36 @@ func waitForCompletion(eventStream io.ReadCloser, sessionID string, index int) (
 			select {
 			case <-done:
 				return
+			case <-ctx.Done():
+				stateMu.Lock()
+				if errorMsg == "" {
+					logf("[%d] Interrupted\n", index)
+					errorMsg = "interrupted"
+					failure = failureInterrupted
+				}
+				stateMu.Unlock()
+				closeDone()
+				eventStream.Close()
+				return
 			case <-ticker.C:
 				stateMu.Lock()
 				inactiveFor := time.Since(lastActivity)
 				alreadyFailed := errorMsg != ""
 				stateMu.Unlock()
-				if !alreadyFailed && inactiveFor > inactivityTimeout {
-					fmt.Printf("[%d] Timed out: no agent activity for %ds\n", index, int(inactivityTimeout.Seconds()))
+				if reason := budget.exceeded(time.Now(), sessionCost(usage)); !alreadyFailed && reason != "" {
+					stopOverBudget(reason)
+					eventStream.Close()
+					return
+				}
+				if !alreadyFailed && inactiveFor > timeout {
+					logf("[%d] Timed out: no agent activity for %ds\n", index, int(timeout.Seconds()))
 					stateMu.Lock()
-					errorMsg = fmt.Sprintf("no agent activity for %ds", int(inactivityTimeout.Seconds()))
+					errorMsg = fmt.Sprintf("no agent activity for %ds", int(timeout.Seconds()))
+					failure = failureInactivity
 					stateMu.Unlock()
 					closeDone()
+					// Unblock the scanner if the stream has gone quiet.
+					eventStream.Close()
 					return
 				}
 			}
@@ -2189,18 +5144,13 @@ func waitForCompletion(eventStream io.ReadCloser, sessionID string, index int) (
 			stateMu.Lock()
 			doneCompleted := completed
 			doneErr := errorMsg
+			doneFailure := failure
 			stateMu.Unlock()
-			return doneCompleted, doneErr
+			return doneCompleted, doneErr, doneFailure
 		default:
 		}
 
 		line := scanner.Text()
-		if strings.TrimSpace(line) != "" {
-			stateMu.Lock()
-			lastActivity = time.Now()
-			stateMu.Unlock()
-		}
-
 		if !strings.HasPrefix(line, "data: ") {
 			continue
 		}
@@ -2218,20 +5168,30 @@ func waitForCompletion(eventStream io.ReadCloser, sessionID string, index int) (
 		}
 
 		// Filter events by session ID
-		if eventSessionID, ok := event.Properties["sessionID"].(string); ok {
+		if eventSessionID, ok := sessionIDOfEvent(event); ok {
 			if eventSessionID != sessionID {
 				continue
 			}
 		}
 
+		// Only this session's events count, so a busy neighbour on a shared
+		// server cannot keep a stalled eval alive.
+		stateMu.Lock()
+		lastActivity = time.Now()
+		stateMu.Unlock()
+
+		if eventLog != nil {
+			logEvent(eventLog, data)
+		}
+
 		switch event.Type {
 		case "session.idle":
-			fmt.Printf("[%d] Session idle - agent completed\n", index)
+			logf("[%d] Session idle - agent completed\n", index)
 			stateMu.Lock()
 			completed = true
 			stateMu.Unlock()
 			closeDone()
-			return true, ""
+			return true, "", ""
 
 		case "session.status":
 			// Newer event format: {sessionID, status: {type: "idle"|"busy"|"retry"}}
@@ -2239,52 +5199,63 @@ func waitForCompletion(eventStream io.ReadCloser, sessionID string, index int) (
 				if statusType, ok := status["type"].(string); ok {
 					switch statusType {
 					case "idle":
-						fmt.Printf("[%d] Session idle - agent completed\n", index)
+						logf("[%d] Session idle - agent completed\n", index)
 						stateMu.Lock()
 						completed = true
 						stateMu.Unlock()
 						closeDone()
-						return true, ""
+						return true, "", ""
 					case "busy":
-						fmt.Printf("[%d] Agent working...\n", index)
+						logf("[%d] Agent working...\n", index)
 					case "retry":
 						msg := ""
 						if m, ok := status["message"].(string); ok {
 							msg = m
 						}
-						fmt.Printf("[%d] Retrying: %s\n", index, msg)
+						logf("[%d] Retrying: %s\n", index, msg)
 					}
 				}
 			}
 
 		case "session.error":
-			fmt.Printf("[%d] Session error detected\n", index)
+			logf("[%d] Session error detected\n", index)
 			stateMu.Lock()
 			if errVal, ok := event.Properties["error"]; ok {
 				errorMsg = extractErrorMessage(errVal)
+				failure = sessionErrorCategory(errVal, errorMsg)
 			} else {
 				errorMsg = "unknown session error"
+				failure = failureSessionError
 			}
+			sessionErr, sessionFailure := errorMsg, failure
 			stateMu.Unlock()
 			closeDone()
-			stateMu.Lock()
-			sessionErr := errorMsg
-			stateMu.Unlock()
-			return false, sessionErr
+			return false, sessionErr, sessionFailure
+
+		case "message.updated":
+			// Agent is actively generating — don't spam the log, but keep usage
+			usage.recordMessage(event.Properties)
+			if reason := budget.exceeded(time.Now(), sessionCost(usage)); reason != "" {
+				return stopOverBudget(reason)
+			}
 
-		case "message.updated", "message.part.updated":
-			// Agent is actively generating — don't spam the log
+		case "message.part.updated":
+			usage.recordPart(event.Properties)
+			if reason := budget.exceeded(time.Now(), sessionCost(usage)); reason != "" {
+				return stopOverBudget(reason)
+			}
 
 		default:
-			fmt.Printf("[%d] Event: %s\n", index, event.Type)
+			logf("[%d] Event: %s\n", index, event.Type)
 		}
 	}
 
 	if err := scanner.Err(); err != nil && err != io.EOF {
-		fmt.Printf("[%d] Event stream error: %v\n", index, err)
 		stateMu.Lock()
 		if errorMsg == "" {
+			logf("[%d] Event stream error: %v\n", index, err)
 			errorMsg = fmt.Sprintf("event stream error: %v", err)
+			failure = failureStream
 		}
 		stateMu.Unlock()
 	}
@@ -2293,17 +5264,481 @@ func waitForCompletion(eventStream io.ReadCloser, sessionID string, index int) (
 	stateMu.Lock()
 	finalCompleted := completed
 	finalErr := errorMsg
+	finalFailure := failure
 	stateMu.Unlock()
-	return finalCompleted, finalErr
+	return finalCompleted, finalErr, finalFailure
 }
 
-func isTransientEvalError(errMsg string) bool {
-	if errMsg == "" {
-		return false
+// sessionUsage accumulates cost and token counts for a session. Assistant
+// messages report cumulative usage on every message.updated event, so the
+// latest value per message wins. step-finish parts carry the same numbers per
+// step and are only counted for messages that never reported their own totals.
+type sessionUsage struct {
+	mu        sync.Mutex
+	sessionID string
+	messages  map[string]messageUsage
+	steps     map[string]stepUsage
+}
+
+type messageUsage struct {
+	Cost   float64
+	Tokens TokenUsage
+}
+
+type stepUsage struct {
+	MessageID string
+	messageUsage
+}
+
+func newSessionUsage(sessionID string) *sessionUsage {
+	return &sessionUsage{
+		sessionID: sessionID,
+		messages:  make(map[string]messageUsage),
+		steps:     make(map[string]stepUsage),
+	}
+}
+
+func (s *sessionUsage) recordMessage(props map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	info, ok := props["info"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if role, _ := info["role"].(string); role != "" && role != "assistant" {
+		return
+	}
+	if !s.ownsSession(info) {
+		return
+	}
+	id, _ := info["id"].(string)
+	if id == "" {
+		return
+	}
+
+	s.mu.Lock()
+	s.messages[id] = parseMessageUsage(info)
+	s.mu.Unlock()
+}
+
+func (s *sessionUsage) recordPart(props map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	part, ok := props["part"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if partType, _ := part["type"].(string); partType != "step-finish" {
+		return
+	}
+	if !s.ownsSession(part) {
+		return
+	}
+	id, _ := part["id"].(string)
+	messageID, _ := part["messageID"].(string)
+	if id == "" {
+		return
+	}
+
+	s.mu.Lock()
+	s.steps[id] = stepUsage{MessageID: messageID, messageUsage: parseMessageUsage(part)}
+	s.mu.Unlock()
+}
+
+func (s *sessionUsage) ownsSession(m map[string]interface{}) bool {
+	sessionID, ok := m["sessionID"].(string)
+	return !ok || s.sessionID == "" || sessionID == s.sessionID
+}
+
+func sessionCost(usage *sessionUsage) float64 {
+	cost, _ := usage.totals()
+	return cost
+}
+
+func (s *sessionUsage) totals() (float64, TokenUsage) {
+	if s == nil {
+		return 0, TokenUsage{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cost := 0.0
+	tokens := TokenUsage{}
+	for _, m := range s.messages {
+		cost += m.Cost
+		tokens = tokens.add(m.Tokens)
+	}
+	for _, step := range s.steps {
+		if _, reported := s.messages[step.MessageID]; reported {
+			continue
+		}
+		cost += step.Cost
+		tokens = tokens.add(step.Tokens)
+	}
+	return cost, tokens
+}
+
+func parseMessageUsage(m map[string]interface{}) messageUsage {
+	usage := messageUsage{Cost: numberField(m, "cost")}
+	tokens, ok := m["tokens"].(map[string]interface{})
+	if !ok {
+		return usage
+	}
+	usage.Tokens = TokenUsage{
+		Input:     int(numberField(tokens, "input")),
+		Output:    int(numberField(tokens, "output")),
+		Reasoning: int(numberField(tokens, "reasoning")),
+	}
+	if cache, ok := tokens["cache"].(map[string]interface{}); ok {
+		usage.Tokens.CacheRead = int(numberField(cache, "read"))
+		usage.Tokens.CacheWrite = int(numberField(cache, "write"))
+	}
+	return usage
+}
+
+func numberField(m map[string]interface{}, key string) float64 {
+	if v, ok := m[key].(float64); ok {
+		return v
+	}
+	return 0
+}
+
+// Grader checks an eval folder after the agent goes idle. Every configured
+// check must pass.
+type Grader struct {
+	Command          string   `json:"command,omitempty"`
+	RequiredFiles    []string `json:"required_files,omitempty"`
+	RunSmoke         bool     `json:"run_smoke,omitempty"`
+	ExpectedExitCode int      `json:"expected_exit_code,omitempty"`
+	TimeoutSeconds   int      `json:"timeout_seconds,omitempty"`
+}
+
+type GradeResult struct {
+	Pass        bool
+	Interrupted bool
+	Reason      string
+	Stdout      string
+	Stderr      string
+}
+
+func loadGraders() (map[int]*Grader, error) {
+	data, err := os.ReadFile(gradersFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[int]*Grader{}, nil
+		}
+		return nil, err
+	}
+
+	var raw map[string]*Grader
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", gradersFile, err)
+	}
+	return parseGraderMap(raw, gradersFile)
+}
+
+// parseGraderMap converts graders keyed by prompt ID strings, as written in
+// graders.json and suite files.
+func parseGraderMap(raw map[string]*Grader, source string) (map[int]*Grader, error) {
+	graders := make(map[int]*Grader, len(raw))
+	for key, g := range raw {
+		n, err := strconv.Atoi(strings.TrimSpace(key))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%s: invalid prompt number %q", source, key)
+		}
+		graders[n] = g
+	}
+	return graders, nil
+}
+
+// attachGraders gives tasks without a grader the graders.json entry for their
+// prompt. Suite graders and inline prompt graders are set beforehand and win.
+func attachGraders(tasks []EvalTask) error {
+	graders, err := loadGraders()
+	if err != nil {
+		return err
+	}
+	for i := range tasks {
+		if tasks[i].Grader != nil {
+			continue
+		}
+		if g, ok := graders[tasks[i].PromptNumber]; ok {
+			tasks[i].Grader = g
+		}
+	}
+	return nil
+}
+
+// gradeEval checks the eval folder against g. Once ctx is cancelled no further
+// command starts and a running one is killed, which marks the grade as
+// interrupted rather than failed.
+func gradeEval(ctx context.Context, folderPath string, g *Grader) GradeResult {
+	timeout := defaultGraderTimeout
+	if g.TimeoutSeconds > 0 {
+		timeout = time.Duration(g.TimeoutSeconds) * time.Second
+	}
+
+	var stdout, stderr strings.Builder
+	failures := make([]string, 0)
+
+	for _, pattern := range g.RequiredFiles {
+		matches, err := filepath.Glob(filepath.Join(folderPath, pattern))
+		if err != nil || len(matches) == 0 {
+			failures = append(failures, "missing "+pattern)
+		}
+	}
+
+	if g.Command != "" {
+		code, err := runGraderCommand(ctx, folderPath, g.Command, timeout, &stdout, &stderr)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("command: %v", err))
+		} else if code != 0 {
+			failures = append(failures, fmt.Sprintf("command exited %d", code))
+		}
+	}
+
+	if g.RunSmoke && ctx.Err() == nil {
+		if _, err := os.Stat(filepath.Join(folderPath, ".run")); err != nil {
+			failures = append(failures, "missing .run")
+		} else {
+			code, err := runGraderCommand(ctx, folderPath, "sh .run", timeout, &stdout, &stderr)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf(".run: %v", err))
+			} else if code != g.ExpectedExitCode {
+				failures = append(failures, fmt.Sprintf(".run exited %d, expected %d", code, g.ExpectedExitCode))
+			}
+		}
+	}
+
+	interrupted := ctx.Err() != nil
+	return GradeResult{
+		Pass:        len(failures) == 0 && !interrupted,
+		Interrupted: interrupted,
+		Reason:      strings.Join(failures, "; "),
+		Stdout:      truncateOutput(stdout.String()),
+		Stderr:      truncateOutput(stderr.String()),
+	}
+}
+
+// runGraderCommand runs command with sh in dir and returns its exit code. An
+// error means the command could not run to completion.
+func runGraderCommand(ctx context.Context, dir, command string, timeout time.Duration, stdout, stderr *strings.Builder) (int, error) {
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fmt.Fprintf(stdout, "$ %s\n", command)
+	cmd := exec.CommandContext(cmdCtx, "sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Kill the whole group so dev servers or watchers started by the command
+	// do not outlive it; they may also keep the pipes open.
+	setNewProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return signalProcessGroup(cmd.Process.Pid, os.Kill)
+	}
+	cmd.WaitDelay = 2 * time.Second
+
+	err := cmd.Run()
+	if cmd.Process != nil {
+		signalProcessGroup(cmd.Process.Pid, os.Kill)
+	}
+	if ctx.Err() != nil {
+		return -1, fmt.Errorf("interrupted")
+	}
+	if cmdCtx.Err() == context.DeadlineExceeded {
+		return -1, fmt.Errorf("timed out after %s", timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+func truncateOutput(s string) string {
+	if len(s) <= graderOutputLimit {
+		return s
+	}
+	return "...(truncated)\n" + s[len(s)-graderOutputLimit:]
+}
+
+// sessionIDOfEvent finds the session an event belongs to. Message events
+// carry it on the nested info or part rather than on the properties.
+func sessionIDOfEvent(event Event) (string, bool) {
+	if id, ok := event.Properties["sessionID"].(string); ok {
+		return id, true
+	}
+	for _, key := range []string{"info", "part"} {
+		if nested, ok := event.Properties[key].(map[string]interface{}); ok {
+			if id, ok := nested["sessionID"].(string); ok {
+				return id, true
+			}
+		}
+	}
+	return "", false
+}
+
+type loggedEvent struct {
+	ReceivedAt string          `json:"received_at"`
+	Event      json.RawMessage `json:"event"`
+}
+
+func logEvent(w io.Writer, data string) {
+	line, err := json.Marshal(loggedEvent{
+		ReceivedAt: time.Now().Format(time.RFC3339Nano),
+		Event:      json.RawMessage(data),
+	})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	_, _ = w.Write(line)
+}
+
+type TranscriptMessage struct {
+	Info  map[string]interface{}   `json:"info"`
+	Parts []map[string]interface{} `json:"parts"`
+}
+
+func fetchSessionMessages(client *http.Client, baseURL, sessionID string) ([]TranscriptMessage, error) {
+	resp, err := client.Get(fmt.Sprintf("%s/session/%s/message", baseURL, sessionID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var messages []TranscriptMessage
+	if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+		return nil, fmt.Errorf("parsing messages: %w", err)
+	}
+	return messages, nil
+}
+
+func saveTranscript(folderPath, sessionID string, messages []TranscriptMessage) error {
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(folderPath, "transcript.json"), data, 0644); err != nil {
+		return err
+	}
+	md := renderTranscriptMarkdown(sessionID, messages)
+	return os.WriteFile(filepath.Join(folderPath, "transcript.md"), []byte(md), 0644)
+}
+
+func renderTranscriptMarkdown(sessionID string, messages []TranscriptMessage) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Transcript\n\nSession: `%s`\n", sessionID)
+
+	for _, msg := range messages {
+		role, _ := msg.Info["role"].(string)
+		switch role {
+		case "user":
+			b.WriteString("\n## User\n")
+		case "assistant":
+			b.WriteString("\n## Assistant")
+			if model, _ := msg.Info["modelID"].(string); model != "" {
+				fmt.Fprintf(&b, " (%s)", model)
+			}
+			b.WriteString("\n")
+		default:
+			fmt.Fprintf(&b, "\n## %s\n", role)
+		}
+
+		for _, part := range msg.Parts {
+			partType, _ := part["type"].(string)
+			switch partType {
+			case "text":
+				if text, _ := part["text"].(string); strings.TrimSpace(text) != "" {
+					fmt.Fprintf(&b, "\n%s\n", strings.TrimSpace(text))
+				}
+			case "reasoning":
+				if text, _ := part["text"].(string); strings.TrimSpace(text) != "" {
+					b.WriteString("\n> **Reasoning**\n>\n")
+					for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+						fmt.Fprintf(&b, "> %s\n", line)
+					}
+				}
+			case "tool":
+				writeToolPart(&b, part)
+			case "file":
+				name, _ := part["filename"].(string)
+				if name == "" {
+					name, _ = part["url"].(string)
+				}
+				fmt.Fprintf(&b, "\n**File:** `%s`\n", name)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func writeToolPart(b *strings.Builder, part map[string]interface{}) {
+	tool, _ := part["tool"].(string)
+	state, _ := part["state"].(map[string]interface{})
+	status, _ := state["status"].(string)
+
+	fmt.Fprintf(b, "\n**Tool: %s** (%s)\n", tool, status)
+	if input, ok := state["input"]; ok {
+		if data, err := json.MarshalIndent(input, "", "  "); err == nil {
+			fmt.Fprintf(b, "\n```json\n%s\n```\n", data)
+		}
+	}
+	if output, _ := state["output"].(string); output != "" {
+		fmt.Fprintf(b, "\n```\n%s\n```\n", strings.TrimRight(truncateOutput(output), "\n"))
+	}
+	if errText, _ := state["error"].(string); errText != "" {
+		fmt.Fprintf(b, "\nError: %s\n", errText)
+	}
+}
+
+func applyOutputMode(mode string) error {
+	switch mode {
+	case outputText, outputJSON, outputJSONL:
+		outputMode = mode
+		return nil
+	}
+	return fmt.Errorf("invalid --output %q (use text, json or jsonl)", mode)
+}
+
+// logf writes human progress output. It goes to stderr so stdout only carries
+// results and can be piped.
+func logf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, args...)
+}
+
+func applyBudgets(maxDuration time.Duration, maxCost float64) error {
+	if maxDuration < 0 {
+		return fmt.Errorf("--max-duration must not be negative")
+	}
+	if maxCost < 0 {
+		return fmt.Errorf("--max-cost must not be negative")
+	}
+	maxEvalDuration, maxEvalCost = maxDuration, maxCost
+	return nil
+}
+
+func describeBudgets() string {
+	var parts []string
+	if maxEvalDuration > 0 {
+		parts = append(parts, "max "+maxEvalDuration.String())
+	}
+	if maxEvalCost > 0 {
+		parts = append(parts, "max "+formatCost(maxEvalCost))
 	}
-	return strings.Contains(errMsg, "no agent activity for") ||
-		strings.Contains(errMsg, "event stream error:") ||
-		strings.Contains(errMsg, "agent did not reach idle state")
+	return strings.Join(parts, " · ")
 }
 
 func applyRuntimeOptions(timeoutSeconds, retries int) {
@@ -2340,13 +5775,27 @@ func extractErrorMessage(errVal interface{}) string {
 	return fmt.Sprintf("%v", errVal)
 }
 
-func isModelNotFoundError(errMsg string) (bool, []string) {
-	if !strings.Contains(errMsg, "Model not found") {
-		return false, nil
+// sessionErrorCategory tells model-not-found errors apart from other session
+// errors. opencode names them ProviderModelNotFoundError; some providers only
+// say so in the message.
+func sessionErrorCategory(errVal interface{}, msg string) FailureCategory {
+	if errMap, ok := errVal.(map[string]interface{}); ok {
+		if name, _ := errMap["name"].(string); strings.Contains(name, "ModelNotFound") {
+			return failureModelNotFound
+		}
+	}
+	if strings.Contains(msg, "Model not found") {
+		return failureModelNotFound
 	}
+	return failureSessionError
+}
+
+// modelSuggestions extracts the "Did you mean: a, b?" part of a
+// model-not-found error.
+func modelSuggestions(errMsg string) []string {
 	idx := strings.Index(errMsg, "Did you mean: ")
 	if idx == -1 {
-		return true, nil
+		return nil
 	}
 	suggestionsStr := errMsg[idx+len("Did you mean: "):]
 	suggestionsStr = strings.TrimSuffix(suggestionsStr, "?")
@@ -2354,7 +5803,7 @@ func isModelNotFoundError(errMsg string) (bool, []string) {
 	for i := range suggestions {
 		suggestions[i] = strings.TrimSpace(suggestions[i])
 	}
-	return true, suggestions
+	return suggestions
 }
 
 func promptModelSelector(description string) (string, bool) {
@@ -2430,6 +5879,87 @@ func promptModelSelector(description string) (string, bool) {
 	return strings.TrimSpace(modelStr), false
 }
 
+func promptModelMultiSelector(description string) ([]string, bool) {
+	savedModels, _ := loadSavedModels()
+
+	if len(savedModels) == 0 {
+		var modelStr string
+		form := newEscBackForm(
+			huh.NewGroup(
+				huh.NewInput().
+					Title("Models to use").
+					Description(description + " (comma-separated)").
+					Placeholder("e.g. openrouter/z-ai/glm-5, opencode/kimi-k2.5-free").
+					Value(&modelStr),
+			),
+		)
+		aborted, err := runFormWithBack(form)
+		if err != nil {
+			return nil, true
+		}
+		if aborted {
+			return nil, true
+		}
+		return appendModels(nil, modelStr), false
+	}
+
+	options := make([]huh.Option[string], 0, len(savedModels)+1)
+	for _, m := range savedModels {
+		options = append(options, huh.NewOption(m, m))
+	}
+	options = append(options, huh.NewOption("Type other model(s)...", "__custom__"))
+
+	var selected []string
+	form := newEscBackForm(
+		huh.NewGroup(
+			huh.NewMultiSelect[string]().
+				Title("Models to use").
+				Description(description + ". Use space to select, enter to confirm.").
+				Options(options...).
+				Value(&selected),
+		),
+	)
+	aborted, err := runFormWithBack(form)
+	if err != nil {
+		return nil, true
+	}
+	if aborted {
+		return nil, true
+	}
+
+	models := make([]string, 0, len(selected))
+	custom := false
+	for _, m := range selected {
+		if m == "__custom__" {
+			custom = true
+			continue
+		}
+		models = append(models, m)
+	}
+	if !custom {
+		return models, false
+	}
+
+	var modelStr string
+	inputForm := newEscBackForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Enter model IDs").
+				Description("Comma-separated").
+				Placeholder("e.g. openrouter/z-ai/glm-5").
+				Value(&modelStr),
+		),
+	)
+	aborted, err = runFormWithBack(inputForm)
+	if err != nil {
+		return nil, true
+	}
+	if aborted {
+		return nil, true
+	}
+	return appendModels(models, modelStr), false
+}
+
 func promptModelCorrection(currentModel string, suggestions []string) (string, bool) {
 	savedModels, _ := loadSavedModels()
 
@@ -1,7 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -90,24 +103,55 @@ func TestPinSavedModelIDsMovesSavedToFront(t *testing.T) {
 	}
 }
 
-func TestIsTransientEvalError(t *testing.T) {
+func TestFailureCategoryTransient(t *testing.T) {
+	for _, c := range failureCategories {
+		want := c == failureInactivity || c == failureStream
+		if got := c.transient(); got != want {
+			t.Fatalf("%s.transient() = %v, want %v", c, got, want)
+		}
+	}
+	if FailureCategory("").transient() {
+		t.Fatalf("a passing eval must not be retried")
+	}
+}
+
+func TestLegacyFailureCategory(t *testing.T) {
 	cases := []struct {
-		errMsg    string
-		transient bool
+		errMsg string
+		want   FailureCategory
 	}{
-		{"no agent activity for 180s", true},
-		{"event stream error: bufio.Scanner: token too long", true},
-		{"agent did not reach idle state", true},
-		{"Failed to send prompt: HTTP 401", false},
-		{"", false},
+		{"no agent activity for 180s", failureInactivity},
+		{"event stream error: bufio.Scanner: token too long", failureStream},
+		{"agent did not reach idle state", failureStream},
+		{"Failed to send prompt: HTTP 401", failureSendPrompt},
+		{"Server not ready after 15s: connection refused", failureSessionCreate},
+		{"Model not found: a/x. Did you mean: a/y?", failureModelNotFound},
+		{"grader failed: missing index.html", failureGrader},
+		{"budget exceeded: cost $1.2000 is over $1.0000 (--max-cost)", failureBudget},
+		{"interrupted", failureInterrupted},
+		{"not started: run interrupted", failureInterrupted},
+		{"provider error: stream interrupted by upstream", failureSessionError},
+		{"Rate limit reached", failureSessionError},
 	}
-
 	for _, tc := range cases {
-		got := isTransientEvalError(tc.errMsg)
-		if got != tc.transient {
-			t.Fatalf("isTransientEvalError(%q) = %v, want %v", tc.errMsg, got, tc.transient)
+		if got := legacyFailureCategory(EvalResultFile{Error: tc.errMsg}); got != tc.want {
+			t.Fatalf("legacyFailureCategory(%q) = %q, want %q", tc.errMsg, got, tc.want)
 		}
 	}
+	if got := legacyFailureCategory(EvalResultFile{Success: true}); got != "" {
+		t.Fatalf("expected no category for a passing eval, got %q", got)
+	}
+}
+
+func TestReadEvalFolderClassifiesLegacyFailures(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "prompt.txt"), []byte("hi"), 0644)
+	os.WriteFile(filepath.Join(dir, "result.json"), []byte(`{"model": "a/x", "success": false, "error": "no agent activity for 180s"}`), 0644)
+
+	ef, ok := readEvalFolder(dir, nil)
+	if !ok || ef.Result == nil || ef.Result.Failure != failureInactivity {
+		t.Fatalf("expected legacy result to be classified as inactivity, got %+v", ef.Result)
+	}
 }
 
 func TestApplyRuntimeOptions(t *testing.T) {
@@ -155,7 +199,7 @@ func TestSanitizeModelForFolder(t *testing.T) {
 }
 
 func TestCreateTimestampFolderIncludesModel(t *testing.T) {
-	folder := createTimestampFolder(3, 12, "openrouter/z-ai/glm-5")
+	folder := createTimestampFolder(3, 12, 0, "openrouter/z-ai/glm-5")
 
 	if !strings.HasPrefix(folder, "evals/") {
 		t.Fatalf("expected folder to start with evals/, got %q", folder)
@@ -165,6 +209,17 @@ func TestCreateTimestampFolderIncludesModel(t *testing.T) {
 	}
 }
 
+func TestCreateTimestampFolderIncludesTrial(t *testing.T) {
+	folder := createTimestampFolder(3, 12, 2, "openrouter/z-ai/glm-5")
+
+	if !strings.Contains(folder, "_p12_t2_3_openrouter-z-ai-glm-5") {
+		t.Fatalf("expected folder to include trial index, got %q", folder)
+	}
+	if got := parsePromptNumberFromFolder(filepath.Base(folder)); got != 12 {
+		t.Fatalf("expected prompt number 12 from trial folder, got %d", got)
+	}
+}
+
 func TestParsePromptNumberFromFolder(t *testing.T) {
 	if got := parsePromptNumberFromFolder("2026-02-16_09-35-43_p7_3_openrouter-z-ai-glm-5"); got != 7 {
 		t.Fatalf("expected prompt number 7, got %d", got)
@@ -173,3 +228,1112 @@ func TestParsePromptNumberFromFolder(t *testing.T) {
 		t.Fatalf("expected 0 for folder without prompt marker, got %d", got)
 	}
 }
+
+func TestWaitForCompletionAccumulatesUsage(t *testing.T) {
+	stream := strings.Join([]string{
+		`data: {"type":"message.updated","properties":{"info":{"id":"m1","sessionID":"s1","role":"assistant","cost":0.01,"tokens":{"input":100,"output":10,"reasoning":0,"cache":{"read":0,"write":0}}}}}`,
+		`data: {"type":"message.updated","properties":{"info":{"id":"m1","sessionID":"s1","role":"assistant","cost":0.02,"tokens":{"input":200,"output":20,"reasoning":5,"cache":{"read":50,"write":7}}}}}`,
+		`data: {"type":"message.updated","properties":{"info":{"id":"u1","sessionID":"s1","role":"user"}}}`,
+		`data: {"type":"message.part.updated","properties":{"part":{"id":"p1","messageID":"m1","sessionID":"s1","type":"step-finish","cost":0.5,"tokens":{"input":1,"output":1,"reasoning":0,"cache":{"read":0,"write":0}}}}}`,
+		`data: {"type":"message.part.updated","properties":{"part":{"id":"p2","messageID":"m2","sessionID":"s1","type":"step-finish","cost":0.03,"tokens":{"input":30,"output":3,"reasoning":0,"cache":{"read":0,"write":0}}}}}`,
+		`data: {"type":"message.updated","properties":{"info":{"id":"m9","sessionID":"other","role":"assistant","cost":9,"tokens":{"input":9}}}}`,
+		`data: {"type":"session.idle","properties":{"sessionID":"s1"}}`,
+		"",
+	}, "\n")
+
+	usage := newSessionUsage("s1")
+	completed, errMsg, _ := waitForCompletion(context.Background(), io.NopCloser(strings.NewReader(stream)), "s1", 0, time.Minute, evalBudget{}, usage, nil)
+	if !completed || errMsg != "" {
+		t.Fatalf("expected completion without error, got completed=%v err=%q", completed, errMsg)
+	}
+
+	cost, tokens := usage.totals()
+	if math.Abs(cost-0.05) > 1e-9 {
+		t.Fatalf("expected cost 0.05, got %v", cost)
+	}
+	want := TokenUsage{Input: 230, Output: 23, Reasoning: 5, CacheRead: 50, CacheWrite: 7}
+	if tokens != want {
+		t.Fatalf("expected tokens %+v, got %+v", want, tokens)
+	}
+}
+
+func TestRenderPromptSubstitutesVariables(t *testing.T) {
+	vars := builtinPromptVars("openrouter/z-ai/glm-5", 4, "evals/x", time.Date(2026, 2, 16, 9, 0, 0, 0, time.UTC))
+	vars["NOTION_PAGE_URL_OR_ID"] = "abc123"
+	declared := []string{"NOTION_PAGE_URL_OR_ID"}
+
+	got, err := renderPrompt("Show <MODEL_NAME> for page <NOTION_PAGE_URL_OR_ID> (#<PROMPT_NUMBER>) in <div> and <HTML>", declared, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "Show openrouter/z-ai/glm-5 for page abc123 (#4) in <div> and <HTML>"
+	if got != want {
+		t.Fatalf("renderPrompt = %q, want %q", got, want)
+	}
+
+	if _, err := renderPrompt("Needs <MISSING_VAR>", []string{"MISSING_VAR"}, vars); err == nil {
+		t.Fatalf("expected error for missing variable")
+	}
+}
+
+func TestDetectPromptVars(t *testing.T) {
+	got := detectPromptVars("Show <MODEL_NAME> on <PAGE> and <PAGE> with <HTML>")
+	if strings.Join(got, ",") != "PAGE,HTML" {
+		t.Fatalf("detectPromptVars = %v", got)
+	}
+
+	pf, migrated, err := parsePromptFile([]byte(`{"version": 2, "next_id": 2, "prompts": [{"id": 1, "text": "Page <PAGE> for <MODEL_NAME>"}]}`))
+	if err != nil || !migrated {
+		t.Fatalf("expected version 2 to migrate, got %v, %v", migrated, err)
+	}
+	if strings.Join(pf.Prompts[0].Vars, ",") != "PAGE" {
+		t.Fatalf("expected migrated prompt to declare PAGE, got %v", pf.Prompts[0].Vars)
+	}
+}
+
+func TestRedactVarArgs(t *testing.T) {
+	args := []string{"-m", "a/x", "--var", "TOKEN=secret", "-var=URL=https://u:p@host", "-p", "1"}
+	got := strings.Join(redactVarArgs(args), " ")
+	want := "-m a/x --var TOKEN=<redacted> -var=URL=<redacted> -p 1"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if args[3] != "TOKEN=secret" {
+		t.Fatalf("expected the input to be left untouched")
+	}
+
+	vars := varFlags{"TOKEN=secret", "PAGE=abc"}
+	if got := vars.redacted(); got != "TOKEN=<redacted>,PAGE=<redacted>" {
+		t.Fatalf("unexpected redacted flag value %q", got)
+	}
+}
+
+func TestCheckPromptVarsReportsMissing(t *testing.T) {
+	orig := promptVars
+	t.Cleanup(func() { promptVars = orig })
+
+	if err := applyPromptVars(nil, "", []string{"NOTION_PAGE_URL_OR_ID=abc"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tasks := []EvalTask{
+		{Prompt: "Model <MODEL_NAME> page <NOTION_PAGE_URL_OR_ID> as <HTML>", PromptNumber: 1, Vars: []string{"NOTION_PAGE_URL_OR_ID"}},
+	}
+	if err := checkPromptVars(tasks); err != nil {
+		t.Fatalf("expected all variables to resolve, got %v", err)
+	}
+
+	tasks = append(tasks, EvalTask{Prompt: "Key <API_KEY> and <API_KEY>", PromptNumber: 2, Vars: []string{"API_KEY"}})
+	err := checkPromptVars(tasks)
+	if err == nil || !strings.Contains(err.Error(), "prompt #2 needs API_KEY") {
+		t.Fatalf("expected missing API_KEY for prompt #2, got %v", err)
+	}
+
+	if err := applyPromptVars(nil, "", []string{"MODEL_NAME=x"}); err == nil {
+		t.Fatalf("expected error when overriding a built-in variable")
+	}
+	if err := applyPromptVars(nil, "", []string{"novalue"}); err == nil {
+		t.Fatalf("expected error for malformed --var")
+	}
+}
+
+func TestModelFlagsSplitsAndDedupes(t *testing.T) {
+	var models modelFlags
+	_ = models.Set("openrouter/z-ai/glm-5, opencode/kimi-k2.5-free")
+	_ = models.Set("openrouter/z-ai/glm-5")
+	_ = models.Set("anthropic/claude-sonnet-4,")
+
+	want := []string{"openrouter/z-ai/glm-5", "opencode/kimi-k2.5-free", "anthropic/claude-sonnet-4"}
+	if strings.Join(models, "|") != strings.Join(want, "|") {
+		t.Fatalf("expected models %v, got %v", want, []string(models))
+	}
+}
+
+func TestPrintMatrixTable(t *testing.T) {
+	results := []EvalResult{
+		{PromptNumber: 3, Model: "a/one", Success: true, Duration: 73 * time.Second},
+		{PromptNumber: 1, Model: "a/one", Success: false, Duration: 12 * time.Second},
+		{PromptNumber: 1, Model: "b/two", Success: true, Duration: 40 * time.Second},
+	}
+
+	var b strings.Builder
+	printMatrixTable(&b, results, resultModels(results))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header plus 2 prompt rows, got %q", b.String())
+	}
+	if !strings.HasPrefix(lines[0], "Prompt") || !strings.Contains(lines[0], "a/one") || !strings.Contains(lines[0], "b/two") {
+		t.Fatalf("unexpected header %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "p1") || !strings.Contains(lines[1], "✗ 12s") || !strings.Contains(lines[1], "✓ 40s") {
+		t.Fatalf("unexpected p1 row %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "p3") || !strings.Contains(lines[2], "✓ 73s") || !strings.HasSuffix(lines[2], "-") {
+		t.Fatalf("unexpected p3 row %q", lines[2])
+	}
+}
+
+func TestGradeEval(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".run"), []byte("echo smoke; exit 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	grade := gradeEval(context.Background(), dir, &Grader{
+		Command:          "test -f index.html && echo ok",
+		RequiredFiles:    []string{"*.html"},
+		RunSmoke:         true,
+		ExpectedExitCode: 3,
+	})
+	if !grade.Pass {
+		t.Fatalf("expected grader to pass, got reason %q", grade.Reason)
+	}
+	if !strings.Contains(grade.Stdout, "ok") || !strings.Contains(grade.Stdout, "smoke") {
+		t.Fatalf("expected grader stdout to be captured, got %q", grade.Stdout)
+	}
+
+	grade = gradeEval(context.Background(), dir, &Grader{
+		Command:       "echo broken >&2; exit 1",
+		RequiredFiles: []string{"main.py"},
+	})
+	if grade.Pass {
+		t.Fatalf("expected grader to fail")
+	}
+	if !strings.Contains(grade.Reason, "missing main.py") || !strings.Contains(grade.Reason, "command exited 1") {
+		t.Fatalf("unexpected failure reason %q", grade.Reason)
+	}
+	if !strings.Contains(grade.Stderr, "broken") {
+		t.Fatalf("expected grader stderr to be captured, got %q", grade.Stderr)
+	}
+}
+
+func TestAttachGradersPrecedence(t *testing.T) {
+	t.Chdir(t.TempDir())
+	data := `{"1": {"command": "file1"}, "2": {"command": "file2"}, "3": {"command": "file3"}}`
+	if err := os.WriteFile(gradersFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tasks := []EvalTask{
+		{PromptNumber: 1, Grader: &Grader{Command: "suite"}},
+		{PromptNumber: 2, Grader: &Grader{Command: "inline"}},
+		{PromptNumber: 3},
+		{PromptNumber: 4},
+	}
+	if err := attachGraders(tasks); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"suite", "inline", "file3"} {
+		if tasks[i].Grader == nil || tasks[i].Grader.Command != want {
+			t.Fatalf("task %d: expected grader %q, got %+v", i, want, tasks[i].Grader)
+		}
+	}
+	if tasks[3].Grader != nil {
+		t.Fatalf("expected no grader for a prompt without one, got %+v", tasks[3].Grader)
+	}
+}
+
+func TestRunGraderCommandKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are not supported on windows")
+	}
+	for _, tc := range []struct {
+		name    string
+		command string
+		timeout time.Duration
+	}{
+		{"exit", "sleep 60 >/dev/null 2>&1 & echo $!", time.Minute},
+		{"timeout", "sleep 60 & echo $!; wait", 500 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			runGraderCommand(context.Background(), t.TempDir(), tc.command, tc.timeout, &stdout, &stderr)
+			lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+			pid, err := strconv.Atoi(lines[len(lines)-1])
+			if err != nil {
+				t.Fatalf("expected the background PID on stdout, got %q", stdout.String())
+			}
+			proc, _ := os.FindProcess(pid)
+			deadline := time.Now().Add(2 * time.Second)
+			for proc.Signal(syscall.Signal(0)) == nil && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if proc.Signal(syscall.Signal(0)) == nil {
+				proc.Kill()
+				t.Fatalf("expected background process %d to be killed", pid)
+			}
+		})
+	}
+}
+
+func TestParsePromptFileMigratesLegacyArray(t *testing.T) {
+	pf, migrated, err := parsePromptFile([]byte(`["first", "second <MODEL_NAME>"]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !migrated {
+		t.Fatalf("expected legacy array to be reported as migrated")
+	}
+	if pf.Version != promptSchemaVersion || pf.NextID != 3 || len(pf.Prompts) != 2 {
+		t.Fatalf("unexpected migrated file: %+v", pf)
+	}
+	if pf.Prompts[1].ID != 2 || pf.Prompts[1].Text != "second <MODEL_NAME>" {
+		t.Fatalf("expected second prompt to keep number 2, got %+v", pf.Prompts[1])
+	}
+}
+
+func TestLoadPromptsDoesNotRewriteFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	legacy := []byte(`["first", "second"]`)
+	if err := os.WriteFile(promptsFile, legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pf, err := loadPrompts()
+	if err != nil || len(pf.Prompts) != 2 || pf.Version != promptSchemaVersion {
+		t.Fatalf("loadPrompts = %+v, %v", pf, err)
+	}
+	if data, _ := os.ReadFile(promptsFile); string(data) != string(legacy) {
+		t.Fatalf("expected loadPrompts to leave %s untouched, got %s", promptsFile, data)
+	}
+}
+
+func TestPromptFileIDsAreStable(t *testing.T) {
+	pf, migrated, err := parsePromptFile([]byte(`{
+  "version": 3,
+  "next_id": 4,
+  "prompts": [
+    {"id": 1, "text": "one", "tags": ["web"], "timeout_seconds": 240, "grader": {"command": "true"}},
+    {"id": 3, "text": "three"}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if migrated {
+		t.Fatalf("expected current schema not to be migrated")
+	}
+
+	added := pf.add(PromptRecord{Text: "four"})
+	if added.ID != 4 {
+		t.Fatalf("expected new prompt to get id 4, got %d", added.ID)
+	}
+
+	rec, ok := pf.find(1)
+	if !ok {
+		t.Fatalf("expected prompt 1 to exist")
+	}
+	task := rec.task()
+	if task.PromptNumber != 1 || task.InactivityTimeout != 240*time.Second || task.Grader == nil {
+		t.Fatalf("unexpected task from record: %+v", task)
+	}
+
+	if _, _, err := parsePromptFile([]byte(`{"version": 2, "prompts": [{"id": 2, "text": "a"}, {"id": 2, "text": "b"}]}`)); err == nil {
+		t.Fatalf("expected duplicate ids to be rejected")
+	}
+}
+
+func TestPortAllocatorReservesPorts(t *testing.T) {
+	ports := &portAllocator{reserved: make(map[int]bool)}
+
+	first, err := ports.allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ports.allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("expected distinct ports, got %d twice", first)
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", first))
+	if err != nil {
+		t.Fatalf("expected allocated port %d to be free: %v", first, err)
+	}
+	l.Close()
+
+	ports.release(first)
+	if ports.reserved[first] || !ports.reserved[second] {
+		t.Fatalf("unexpected reservations after release: %v", ports.reserved)
+	}
+}
+
+func TestWaitForCompletionLogsSessionEvents(t *testing.T) {
+	stream := strings.Join([]string{
+		`data: {"type":"server.heartbeat","properties":{}}`,
+		`data: {"type":"session.status","properties":{"sessionID":"s1","status":{"type":"busy"}}}`,
+		`data: {"type":"message.part.updated","properties":{"part":{"id":"p1","sessionID":"other","type":"text","text":"hi"}}}`,
+		`data: {"type":"message.part.updated","properties":{"part":{"id":"p2","sessionID":"s1","type":"text","text":"hello"}}}`,
+		`data: {"type":"session.idle","properties":{"sessionID":"s1"}}`,
+		"",
+	}, "\n")
+
+	var log strings.Builder
+	completed, _, _ := waitForCompletion(context.Background(), io.NopCloser(strings.NewReader(stream)), "s1", 0, time.Minute, evalBudget{}, nil, &log)
+	if !completed {
+		t.Fatalf("expected completion")
+	}
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 logged session events, got %d: %q", len(lines), log.String())
+	}
+	var first loggedEvent
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("logged line is not JSON: %v", err)
+	}
+	if first.ReceivedAt == "" || !strings.Contains(string(first.Event), `"session.status"`) {
+		t.Fatalf("unexpected first logged event %+v", first)
+	}
+	if strings.Contains(log.String(), `"other"`) {
+		t.Fatalf("expected events from other sessions to be skipped")
+	}
+}
+
+func TestRenderTranscriptMarkdown(t *testing.T) {
+	messages := []TranscriptMessage{
+		{
+			Info:  map[string]interface{}{"role": "user"},
+			Parts: []map[string]interface{}{{"type": "text", "text": "Build a thing"}},
+		},
+		{
+			Info: map[string]interface{}{"role": "assistant", "modelID": "glm-5"},
+			Parts: []map[string]interface{}{
+				{"type": "reasoning", "text": "Plan first"},
+				{"type": "tool", "tool": "bash", "state": map[string]interface{}{
+					"status": "completed",
+					"input":  map[string]interface{}{"command": "ls"},
+					"output": "index.html\n",
+				}},
+				{"type": "step-finish"},
+				{"type": "text", "text": "Done."},
+			},
+		},
+	}
+
+	md := renderTranscriptMarkdown("s1", messages)
+	for _, want := range []string{"## User", "Build a thing", "## Assistant (glm-5)", "> Plan first", "**Tool: bash** (completed)", `"command": "ls"`, "index.html", "Done."} {
+		if !strings.Contains(md, want) {
+			t.Fatalf("expected transcript to contain %q, got:\n%s", want, md)
+		}
+	}
+}
+
+func TestEvalResultOutputJSON(t *testing.T) {
+	passed := true
+	out := newEvalResultOutput(EvalResult{
+		Folder:         "evals/x_p3_0_openrouter-glm-5",
+		PromptNumber:   3,
+		Model:          "openrouter/glm-5",
+		Success:        true,
+		AgentCompleted: true,
+		GradedPass:     &passed,
+		Duration:       1500 * time.Millisecond,
+		CostUSD:        0.25,
+	})
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{`"folder":"evals/x_p3_0_openrouter-glm-5"`, `"prompt_number":3`, `"model":"openrouter/glm-5"`, `"success":true`, `"duration_seconds":1.5`, `"cost_usd":0.25`, `"graded_pass":true`} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %s in %s", want, data)
+		}
+	}
+	if strings.Contains(string(data), `"tokens"`) || strings.Contains(string(data), `"error"`) {
+		t.Fatalf("expected empty tokens and error to be omitted, got %s", data)
+	}
+}
+
+func TestApplyOutputMode(t *testing.T) {
+	orig := outputMode
+	t.Cleanup(func() { outputMode = orig })
+
+	for _, mode := range []string{"text", "json", "jsonl"} {
+		if err := applyOutputMode(mode); err != nil || outputMode != mode {
+			t.Fatalf("applyOutputMode(%q) = %v, mode %q", mode, err, outputMode)
+		}
+	}
+	if err := applyOutputMode("yaml"); err == nil {
+		t.Fatalf("expected error for unsupported output mode")
+	}
+}
+
+func TestPassGateExitCode(t *testing.T) {
+	results := []EvalResult{{Success: true}, {Success: true}, {Success: true}, {Success: false}}
+	allPassed := []EvalResult{{Success: true}, {Success: true}}
+
+	cases := []struct {
+		name    string
+		gate    passGate
+		results []EvalResult
+		want    int
+	}{
+		{"all passed", passGate{MinPassRate: -1, MaxFailures: -1}, allPassed, exitOK},
+		{"any failure", passGate{MinPassRate: -1, MaxFailures: -1}, results, exitEvalsFailed},
+		{"pass rate met", passGate{MinPassRate: 0.75, MaxFailures: -1}, results, exitOK},
+		{"pass rate not met", passGate{MinPassRate: 0.8, MaxFailures: -1}, results, exitThresholdNotMet},
+		{"failures within limit", passGate{MinPassRate: -1, MaxFailures: 1}, results, exitOK},
+		{"too many failures", passGate{MinPassRate: -1, MaxFailures: 0}, results, exitThresholdNotMet},
+	}
+
+	for _, tc := range cases {
+		if got := tc.gate.exitCode(tc.results); got != tc.want {
+			t.Fatalf("%s: exitCode = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	if err := (passGate{MinPassRate: 80, MaxFailures: -1}).validate(); err == nil {
+		t.Fatalf("expected --min-pass-rate above 1 to be rejected")
+	}
+}
+
+func TestNewAgentBackend(t *testing.T) {
+	backend, err := newAgentBackend("opencode")
+	if err != nil {
+		t.Fatalf("newAgentBackend(opencode): %v", err)
+	}
+	if _, ok := backend.(transcriptSource); !ok {
+		t.Fatalf("opencode backend should provide transcripts")
+	}
+	if _, err := newAgentBackend("nope"); err == nil || !strings.Contains(err.Error(), "opencode") {
+		t.Fatalf("expected unknown backend error listing available backends, got %v", err)
+	}
+}
+
+func TestOpencodeBackendStopKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are not supported on windows")
+	}
+	bin := t.TempDir()
+	// A fake opencode that leaves a child behind, which ignores SIGINT like
+	// background jobs of a non-interactive shell do.
+	script := "#!/bin/sh\nsleep 60 &\nwait\n"
+	if err := os.WriteFile(filepath.Join(bin, "opencode"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	backend := &opencodeBackend{}
+	if err := backend.Start(dir, basePort); err != nil {
+		t.Fatal(err)
+	}
+	pid, err := readAgentPIDFile(filepath.Join(dir, agentPIDFile))
+	if err != nil || pid != backend.cmd.Process.Pid {
+		t.Fatalf("expected PID file with %d, got %d (%v)", backend.cmd.Process.Pid, pid, err)
+	}
+
+	if err := backend.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, agentPIDFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected PID file to be removed, got %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for processGroupAlive(pid) && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if processGroupAlive(pid) {
+		t.Fatalf("expected process group %d to be gone after Stop", pid)
+	}
+}
+
+func TestRunAgentRetriesServerThatExitsDuringStartup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake opencode is a shell script")
+	}
+	bin := t.TempDir()
+	ports := filepath.Join(bin, "ports")
+	// A server that cannot bind its port exits right away.
+	script := "#!/bin/sh\necho \"$2\" >> " + ports + "\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(bin, "opencode"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Chdir(t.TempDir())
+
+	start := time.Now()
+	result := runAgent(context.Background(), EvalTask{Prompt: "hi", PromptNumber: 1, Model: "acme/coder-1"}, 0)
+	if elapsed := time.Since(start); elapsed >= serverReadyTimeout {
+		t.Fatalf("expected the exit to be noticed before the readiness timeout, took %s", elapsed)
+	}
+	if result.Failure != failureServerStart || !strings.Contains(result.Error, "exited during startup") {
+		t.Fatalf("expected a server_start failure, got %+v", result)
+	}
+	data, _ := os.ReadFile(ports)
+	tried := strings.Fields(string(data))
+	if len(tried) != serverStartAttempts || tried[0] == tried[1] {
+		t.Fatalf("expected %d starts on different ports, got %v", serverStartAttempts, tried)
+	}
+}
+
+func TestOcCleanupCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are not supported on windows")
+	}
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "opencode"), []byte("#!/bin/sh\nsleep 60 &\nwait\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	start := func(name string, args ...string) *exec.Cmd {
+		cmd := exec.Command(name, args...)
+		setNewProcessGroup(cmd)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { signalProcessGroup(cmd.Process.Pid, os.Kill) })
+		return cmd
+	}
+	server := start(filepath.Join(bin, "opencode"))
+	go server.Wait()
+	unrelated := start("sleep", "60")
+	go unrelated.Wait()
+	exited := start("true")
+	exited.Wait()
+
+	t.Chdir(t.TempDir())
+	pids := map[string]int{"server": server.Process.Pid, "reused": unrelated.Process.Pid, "dead": exited.Process.Pid}
+	for name, pid := range pids {
+		dir := filepath.Join("evals", name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeAgentPIDFile(dir, pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ocCleanupCommand()
+
+	for name := range pids {
+		if _, err := os.Stat(filepath.Join("evals", name, agentPIDFile)); !os.IsNotExist(err) {
+			t.Fatalf("expected the %s PID file to be removed, got %v", name, err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for processGroupAlive(server.Process.Pid) && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if processGroupAlive(server.Process.Pid) {
+		t.Fatalf("expected the opencode process group to be terminated")
+	}
+	if !processGroupAlive(unrelated.Process.Pid) {
+		t.Fatalf("expected a process that reused the PID to be left running")
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+	result := func(model string, success bool, seconds, attempts int, cost float64) *EvalResultFile {
+		return &EvalResultFile{Model: model, Success: success, DurationSeconds: seconds, Attempts: attempts, CostUSD: cost}
+	}
+	folders := []EvalFolder{
+		{PromptNumber: 1, Result: result("a/x", true, 10, 1, 0.1)},
+		{PromptNumber: 1, Result: result("a/x", false, 30, 2, 0.2)},
+		{PromptNumber: 2, Result: result("a/x", true, 20, 0, 0.1)},
+		{PromptNumber: 1, Result: result("b/y", true, 50, 1, 0.5)},
+		{PromptNumber: 2, Result: result("b/y", true, 70, 3, 0.5)},
+		{PromptNumber: 3},
+	}
+
+	folders[1].Result.Failure = failureInactivity
+	report := buildReport(folders)
+	if len(report.Leaderboard) != 2 || len(report.ByPrompt) != 4 {
+		t.Fatalf("unexpected report shape: %+v", report)
+	}
+
+	top := report.Leaderboard[0]
+	if top.Model != "b/y" || top.Runs != 2 || top.PassRate != 1 || top.Retries != 2 {
+		t.Fatalf("unexpected leader: %+v", top)
+	}
+	if top.MedianDurationSeconds != 60 || top.P90DurationSeconds != 70 || math.Abs(top.CostUSD-1.0) > 1e-9 {
+		t.Fatalf("unexpected leader stats: %+v", top)
+	}
+
+	second := report.Leaderboard[1]
+	if second.Model != "a/x" || second.Passed != 2 || second.Runs != 3 || second.Retries != 1 || second.MedianDurationSeconds != 20 || second.P90DurationSeconds != 30 {
+		t.Fatalf("unexpected second row: %+v", second)
+	}
+	if second.Failures[failureInactivity] != 1 || formatFailures(second.Failures, " ", "-") != "inactivity:1" || top.Failures != nil {
+		t.Fatalf("unexpected failure counts: %v / %v", second.Failures, top.Failures)
+	}
+
+	first := report.ByPrompt[0]
+	if first.PromptNumber != 1 || first.Model != "a/x" || first.Runs != 2 || first.PassRate != 0.5 {
+		t.Fatalf("unexpected first prompt row: %+v", first)
+	}
+}
+
+func TestWriteReportFormats(t *testing.T) {
+	report := buildReport([]EvalFolder{{PromptNumber: 4, Result: &EvalResultFile{Model: "a/x", Success: true, DurationSeconds: 12, CostUSD: 0.05}}})
+
+	var md strings.Builder
+	writeReportMarkdown(&md, report)
+	if !strings.Contains(md.String(), "| 1 | `a/x` | 1 | 1/1 (100%) | 12s | 12s | $0.0500 | 0 | 0 | - |") ||
+		!strings.Contains(md.String(), "| p4 | `a/x` |") {
+		t.Fatalf("unexpected markdown:\n%s", md.String())
+	}
+
+	var csvOut strings.Builder
+	if err := writeReportCSV(&csvOut, report); err != nil {
+		t.Fatalf("writeReportCSV: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(csvOut.String()), "\n")
+	if len(lines) != 3 || lines[1] != "model,a/x,,1,1,1.0000,12,12,0.0500,0,0," || lines[2] != "prompt,a/x,4,1,1,1.0000,12,12,0.0500,0,0," {
+		t.Fatalf("unexpected csv:\n%s", csvOut.String())
+	}
+}
+
+func TestTrialStats(t *testing.T) {
+	results := []EvalResult{
+		{PromptNumber: 1, Model: "a/x", Trial: 1, Success: true, Duration: 10 * time.Second},
+		{PromptNumber: 1, Model: "a/x", Trial: 2, Success: false, Duration: 20 * time.Second},
+		{PromptNumber: 1, Model: "a/x", Trial: 3, Success: true, Duration: 30 * time.Second},
+		{PromptNumber: 1, Model: "a/x", Trial: 4, Success: false, Duration: 40 * time.Second},
+		{PromptNumber: 2, Model: "a/x", Success: true, Duration: time.Second},
+	}
+
+	stats := trialStats(results)
+	if len(stats) != 1 {
+		t.Fatalf("expected only trial results to be grouped, got %+v", stats)
+	}
+	s := stats[0]
+	if s.Trials != 4 || s.Passed != 2 || s.PassAtK != 1 {
+		t.Fatalf("unexpected counts: %+v", s)
+	}
+	if s.MeanSeconds != 25 || math.Abs(s.StddevSeconds-12.9099) > 1e-3 {
+		t.Fatalf("unexpected duration stats: %+v", s)
+	}
+	if math.Abs(s.CILow-0.15) > 0.01 || math.Abs(s.CIHigh-0.85) > 0.01 {
+		t.Fatalf("unexpected confidence interval: [%f, %f]", s.CILow, s.CIHigh)
+	}
+}
+
+func TestPassAtK(t *testing.T) {
+	cases := []struct {
+		n, c, k int
+		want    float64
+	}{
+		{5, 0, 1, 0},
+		{5, 5, 1, 1},
+		{4, 2, 1, 0.5},
+		{4, 2, 2, 1 - 1.0/6},
+		{4, 2, 3, 1},
+	}
+	for _, tc := range cases {
+		if got := passAtK(tc.n, tc.c, tc.k); math.Abs(got-tc.want) > 1e-9 {
+			t.Fatalf("passAtK(%d, %d, %d) = %f, want %f", tc.n, tc.c, tc.k, got, tc.want)
+		}
+	}
+}
+
+func TestLoadSuiteAndApplyFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nightly.json")
+	data := `{"version": 2, "prompts": [1, 3], "models": ["a/x", "b/y"], "mode": "parallel", "retries": 0, "min_pass_rate": 0.5}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	suite, ref, err := loadSuite(path)
+	if err != nil {
+		t.Fatalf("loadSuite: %v", err)
+	}
+	if ref.Name != "nightly" || ref.Version != 2 || !strings.HasPrefix(ref.Hash, "sha256:") || len(ref.Hash) != len("sha256:")+64 {
+		t.Fatalf("unexpected suite ref: %+v", ref)
+	}
+
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	var models modelFlags
+	fs.Var(&models, "m", "")
+	prompts := fs.String("p", "", "")
+	mode := fs.String("mode", "sequential", "")
+	retries := fs.Int("retries", 1, "")
+	minPassRate := fs.Float64("min-pass-rate", -1, "")
+	fs.Int("concurrency", 0, "")
+	fs.Int("trials", 1, "")
+	fs.Int("inactivity-timeout", 180, "")
+	fs.String("backend", "opencode", "")
+	fs.Int("max-failures", -1, "")
+	if err := fs.Parse([]string{"--mode", "sequential", "-m", "c/z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applySuiteFlags(fs, suite); err != nil {
+		t.Fatalf("applySuiteFlags: %v", err)
+	}
+	if *prompts != "1,3" || *retries != 0 || *minPassRate != 0.5 {
+		t.Fatalf("suite values not applied: p=%q retries=%d min-pass-rate=%v", *prompts, *retries, *minPassRate)
+	}
+	if *mode != "sequential" || len(models) != 1 || models[0] != "c/z" {
+		t.Fatalf("explicit flags should win over the suite: mode=%q models=%v", *mode, models)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"prompts": [1], "models": ["a/x"], "modle": "x"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadSuite(path); err == nil {
+		t.Fatalf("expected unknown suite fields to be rejected")
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	now := time.Date(2026, 2, 13, 20, 15, 42, 0, time.UTC)
+	a, b := newRunID(now), newRunID(now)
+	if !strings.HasPrefix(a, "20260213-201542-") || len(a) != len("20260213-201542-")+6 {
+		t.Fatalf("unexpected run ID format: %q", a)
+	}
+	if a == b {
+		t.Fatalf("expected distinct run IDs, got %q twice", a)
+	}
+}
+
+func TestDiffEvalSets(t *testing.T) {
+	eval := func(prompt int, model string, success bool, seconds int, cost float64) EvalFolder {
+		return EvalFolder{PromptNumber: prompt, Result: &EvalResultFile{Model: model, Success: success, DurationSeconds: seconds, CostUSD: cost}}
+	}
+	a := []EvalFolder{
+		eval(1, "a/x", true, 60, 0.1),
+		eval(2, "a/x", false, 30, 0.1),
+		eval(3, "a/x", true, 10, 0.1),
+		eval(4, "a/x", true, 10, 0.1),
+	}
+	b := []EvalFolder{
+		eval(1, "a/x", false, 90, 0.3),
+		eval(2, "a/x", true, 20, 0.1),
+		eval(3, "a/x", true, 12, 0.1),
+		eval(5, "a/x", true, 10, 0.1),
+	}
+
+	entries := diffEvalSets(a, b)
+	want := []string{"regression", "fixed", "unchanged", "removed", "added"}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries, got %+v", len(want), entries)
+	}
+	for i, e := range entries {
+		if e.PromptNumber != i+1 || e.Change != want[i] {
+			t.Fatalf("entry %d = p%d %s, want p%d %s", i, e.PromptNumber, e.Change, i+1, want[i])
+		}
+	}
+	if entries[0].ADurationSeconds != 60 || entries[0].BDurationSeconds != 90 || math.Abs(entries[0].BCostUSD-0.3) > 1e-9 {
+		t.Fatalf("unexpected duration/cost on regression: %+v", entries[0])
+	}
+
+	var out strings.Builder
+	writeDiffText(&out, entries)
+	if !strings.Contains(out.String(), "60s → 90s (+30s)") {
+		t.Fatalf("expected duration change in output:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "1 regression(s), 1 fixed, 1 unchanged, 1 added, 1 removed") {
+		t.Fatalf("unexpected diff summary:\n%s", out.String())
+	}
+}
+
+func TestResolveEvalSetFromRunManifest(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, folder := range []string{"evals/f1_p1_0_a-x", "evals/f2_p2_1_a-x"} {
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(folder, "prompt.txt"), []byte("x"), 0644)
+		os.WriteFile(filepath.Join(folder, "result.json"), []byte(`{"model":"a/x","success":true,"run_id":"run1"}`), 0644)
+	}
+	m := &RunManifest{RunID: "run1", Tasks: []ManifestTask{{Folder: "evals/f1_p1_0_a-x"}}}
+	if err := m.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	fromRun, err := resolveEvalSet("run1")
+	if err != nil || len(fromRun) != 1 || fromRun[0].PromptNumber != 1 {
+		t.Fatalf("resolveEvalSet(run1) = %+v, %v", fromRun, err)
+	}
+	fromGlob, err := resolveEvalSet("evals/f*")
+	if err != nil || len(fromGlob) != 2 {
+		t.Fatalf("resolveEvalSet(glob) = %+v, %v", fromGlob, err)
+	}
+	if _, err := resolveEvalSet("missing"); err == nil {
+		t.Fatalf("expected error for unknown run")
+	}
+}
+
+func TestRunManifestRecordsFoldersAndNotRunTasks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	m := &RunManifest{RunID: "run1", Tasks: []ManifestTask{
+		{Index: 0, Model: "a/x"},
+		{Index: 1, Model: "a/x"},
+		{Index: 2, Model: "a/y", Folder: "evals/f3_p1_2_a-y"},
+	}}
+	if err := m.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	m.recordFolder(0, "evals/f1_p1_0_a-x")
+	var saved RunManifest
+	data, _ := os.ReadFile(m.path())
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Tasks[0].Folder != "evals/f1_p1_0_a-x" || saved.FinishedAt != "" {
+		t.Fatalf("expected the folder to be written before finish, got %+v (%v)", saved.Tasks, err)
+	}
+
+	m.finish([]EvalResult{
+		{Model: "a/x", Folder: "evals/f1_p1_0_a-x", Success: true, Attempts: 1},
+		{},
+		notStartedResult(EvalTask{Model: "a/y", Folder: "evals/f3_p1_2_a-y"}, "run interrupted"),
+	}, exitInterrupted)
+	if task := m.Tasks[0]; task.Success == nil || !*task.Success || task.NotRun {
+		t.Fatalf("expected task 0 to pass, got %+v", task)
+	}
+	if task := m.Tasks[1]; task.Model != "a/x" || task.Success != nil || !task.NotRun {
+		t.Fatalf("expected task 1 to keep its model and be marked not run, got %+v", task)
+	}
+	if task := m.Tasks[2]; task.Folder != "evals/f3_p1_2_a-y" || !task.NotRun || task.Failure != failureInterrupted {
+		t.Fatalf("expected task 2 to keep its folder and be marked not run, got %+v", task)
+	}
+}
+
+func TestDiffRunAgainstResumeOfItsFolders(t *testing.T) {
+	t.Chdir(t.TempDir())
+	folder := "evals/f1_p1_0_a-x"
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(folder, "prompt.txt"), []byte("x"), 0644)
+
+	saveEvalResult(folder, EvalResult{Success: true, Duration: 40 * time.Second, CostUSD: 0.4, Attempts: 1, RunID: "runA"}, "a/x")
+	saveEvalResult(folder, EvalResult{Error: "grader failed", Failure: failureGrader, Duration: 10 * time.Second, CostUSD: 0.1, Attempts: 1, RunID: "runB"}, "a/x")
+	for _, id := range []string{"runA", "runB"} {
+		m := &RunManifest{RunID: id, Tasks: []ManifestTask{{Folder: folder}}}
+		if err := m.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, errA := resolveEvalSet("runA")
+	b, errB := resolveEvalSet("runB")
+	if errA != nil || errB != nil {
+		t.Fatalf("resolveEvalSet: %v, %v", errA, errB)
+	}
+	if !a[0].Result.Success || a[0].Result.DurationSeconds != 40 || a[0].Result.CostUSD != 0.4 {
+		t.Fatalf("expected runA to report its own attempt, got %+v", a[0].Result)
+	}
+	entries := diffEvalSets(a, b)
+	if len(entries) != 1 || entries[0].Change != "regression" || entries[0].ADurationSeconds != 40 || entries[0].BDurationSeconds != 10 {
+		t.Fatalf("expected a regression from runA to runB, got %+v", entries)
+	}
+}
+
+func TestDiffCountsMissingRunResultsAsFailures(t *testing.T) {
+	t.Chdir(t.TempDir())
+	folder := "evals/f1_p1_0_a-x"
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(folder, "prompt.txt"), []byte("x"), 0644)
+	saveEvalResult(folder, EvalResult{Success: true, Attempts: 1, RunID: "runA"}, "a/x")
+	manifests := []*RunManifest{
+		{RunID: "runA", Tasks: []ManifestTask{{PromptNumber: 1, Model: "a/x", Folder: folder}}},
+		{RunID: "runB", Tasks: []ManifestTask{{PromptNumber: 1, Model: "a/x", Folder: folder}, {Index: 1, PromptNumber: 2, Model: "a/x"}}},
+	}
+	for _, m := range manifests {
+		if err := m.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, errA := resolveEvalSet("runA")
+	b, errB := resolveEvalSet("runB")
+	if errA != nil || errB != nil {
+		t.Fatalf("resolveEvalSet: %v, %v", errA, errB)
+	}
+	if len(b) != 2 || !b[0].Missing || !b[1].Missing || b[1].PromptNumber != 2 {
+		t.Fatalf("expected both runB tasks to be missing, got %+v", b)
+	}
+	entries := diffEvalSets(a, b)
+	if len(entries) != 2 || entries[0].Change != "regression" || entries[0].BMissing != 1 || entries[1].Change != "added" {
+		t.Fatalf("expected a missing result to count as a regression, got %+v", entries)
+	}
+	var out strings.Builder
+	writeDiffText(&out, entries)
+	if !strings.Contains(out.String(), "missing") {
+		t.Fatalf("expected missing results in the diff table:\n%s", out.String())
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("format", "text", "")
+	failOnMissing := fs.Bool("fail-on-missing", false, "")
+	positional, err := parseInterspersed(fs, []string{"runA", "--format", "json", "runB", "--fail-on-missing"})
+	if err != nil || len(positional) != 2 || positional[0] != "runA" || positional[1] != "runB" || *format != "json" || !*failOnMissing {
+		t.Fatalf("parseInterspersed = %v, %v (format %q, fail-on-missing %v)", positional, err, *format, *failOnMissing)
+	}
+}
+
+func TestResumeFilter(t *testing.T) {
+	folders := []EvalFolder{
+		{Path: "evals/2026-09-30_23-00-00_p3_0_a-x", PromptNumber: 3, Result: &EvalResultFile{Model: "a/x", Success: false, Failure: failureInactivity}},
+		{Path: "evals/2026-10-02_08-00-00_p3_1_b-y", PromptNumber: 3, Result: &EvalResultFile{Model: "b/y", Success: true}},
+		{Path: "evals/2026-10-02_09-00-00_p5_2_a-x", PromptNumber: 5},
+		{Path: "evals/2026-10-03_09-00-00_p5_3_a-x", PromptNumber: 5, Result: &EvalResultFile{Model: "a/x", Success: false, Failure: failureGrader}},
+	}
+
+	cases := []struct {
+		name    string
+		failed  bool
+		incompl bool
+		failure string
+		models  []string
+		prompts string
+		since   string
+		glob    string
+		want    []int
+	}{
+		{name: "failed", failed: true, want: []int{0, 3}},
+		{name: "failed or incomplete", failed: true, incompl: true, want: []int{0, 2, 3}},
+		{name: "failure category", failure: "inactivity,stream", want: []int{0}},
+		{name: "model", models: []string{"a/x"}, want: []int{0, 3}},
+		{name: "prompt", prompts: "5", want: []int{2, 3}},
+		{name: "since", failed: true, since: "2026-10-01", want: []int{3}},
+		{name: "glob on base name", glob: "2026-10-02_*", want: []int{1, 2}},
+		{name: "glob on path", glob: "evals/*_p3_*", want: []int{0, 1}},
+	}
+	for _, tc := range cases {
+		f, err := newResumeFilter(tc.failed, tc.incompl, false, tc.failure, tc.models, tc.prompts, tc.since, tc.glob)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !f.active() {
+			t.Fatalf("%s: expected filter to be active", tc.name)
+		}
+		got := f.selectFolders(folders)
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: selected %v, want %v", tc.name, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Fatalf("%s: selected %v, want %v", tc.name, got, tc.want)
+			}
+		}
+	}
+
+	flakyFolders := append(folders, EvalFolder{Path: "evals/x", Result: &EvalResultFile{Success: true, History: &AttemptHistory{Attempts: 2, Passed: 1, Failed: 1}}})
+	if f, _ := newResumeFilter(false, false, true, "", nil, "", "", ""); len(f.selectFolders(flakyFolders)) != 1 {
+		t.Fatalf("expected --flaky to select only the flaky eval")
+	}
+	if f, _ := newResumeFilter(false, false, false, "", nil, "", "", ""); f.active() {
+		t.Fatalf("expected empty filter to be inactive")
+	}
+	if _, err := newResumeFilter(false, false, false, "", nil, "x", "", ""); err == nil {
+		t.Fatalf("expected invalid --prompt to fail")
+	}
+	if _, err := newResumeFilter(false, false, false, "", nil, "", "yesterday", ""); err == nil {
+		t.Fatalf("expected invalid --since to fail")
+	}
+	if _, err := newResumeFilter(false, false, false, "timeout", nil, "", "", ""); err == nil || !strings.Contains(err.Error(), "inactivity") {
+		t.Fatalf("expected unknown failure category to fail and list the known ones, got %v", err)
+	}
+}
+
+func TestResumeModel(t *testing.T) {
+	recorded := EvalFolder{Result: &EvalResultFile{Model: "a/x"}}
+	if got := resumeModel("", recorded); got != "a/x" {
+		t.Fatalf("expected recorded model, got %q", got)
+	}
+	if got := resumeModel("b/y", recorded); got != "b/y" {
+		t.Fatalf("expected override, got %q", got)
+	}
+	if got := resumeModel("", EvalFolder{}); got != defaultEvalModel {
+		t.Fatalf("expected default model for incomplete eval, got %q", got)
+	}
+}
+
+func TestSaveEvalResultKeepsAttemptHistory(t *testing.T) {
+	dir := t.TempDir()
+	legacy := `{"model": "a/x", "success": true, "duration_seconds": 40, "completed_at": "2026-02-13T20:15:42Z"}`
+	if err := os.WriteFile(filepath.Join(dir, "result.json"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saveEvalResult(dir, EvalResult{Error: "no agent activity for 180s", Failure: failureInactivity, Attempts: 1, RunID: "r1"}, "a/x")
+	saveEvalResult(dir, EvalResult{Success: true, Attempts: 2, RunID: "r1"}, "b/y")
+
+	records, err := loadAttempts(dir)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("expected every try to be recorded, got %+v (%v)", records, err)
+	}
+	if !records[0].Success || records[0].DurationSeconds != 40 || records[0].CompletedAt != "2026-02-13T20:15:42Z" {
+		t.Fatalf("expected legacy result as attempt 1, got %+v", records[0])
+	}
+	if records[1].Attempt != 2 || records[1].Success || records[1].Failure != failureInactivity || records[1].Retry != 0 || records[1].RunID != "r1" {
+		t.Fatalf("expected the transient failure as attempt 2, got %+v", records[1])
+	}
+	if records[2].Attempt != 3 || !records[2].Success || records[2].Model != "b/y" || records[2].Retry != 1 || records[2].RunID != "r1" {
+		t.Fatalf("unexpected attempt 3: %+v", records[2])
+	}
+
+	saveEvalResult(dir, EvalResult{Error: "grader failed", Failure: failureGrader, Attempts: 1, RunID: "r2"}, "b/y")
+	records, err = loadAttempts(dir)
+	if err != nil || len(records) != 4 {
+		t.Fatalf("expected 4 attempts, got %+v (%v)", records, err)
+	}
+	if records[3].Attempt != 4 || records[3].Success || records[3].Retry != 0 || records[3].RunID != "r2" {
+		t.Fatalf("unexpected attempt 4: %+v", records[3])
+	}
+	if _, err := os.Stat(filepath.Join(dir, attemptsDir, "004.json")); err != nil {
+		t.Fatalf("expected attempts/004.json: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "result.json"))
+	var rf EvalResultFile
+	if err := json.Unmarshal(data, &rf); err != nil {
+		t.Fatal(err)
+	}
+	if rf.Model != "b/y" || rf.Success || rf.History == nil || *rf.History != (AttemptHistory{Attempts: 3, Passed: 2, Failed: 1}) {
+		t.Fatalf("unexpected result.json summary: %+v history %+v", rf, rf.History)
+	}
+	if !rf.History.flaky() {
+		t.Fatalf("expected mixed history to be flaky")
+	}
+}
+
+func TestArchiveEvalWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	if err := setupEvalFolder(dir, "Build {{NAME}}", "Build app"); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.WriteFile(filepath.Join(dir, "src", "index.js"), []byte("broken"), 0644)
+	saveEvalResult(dir, EvalResult{Error: "no agent activity for 180s", Attempts: 1}, "a/x")
+
+	archive, err := archiveEvalWorkspace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive != filepath.Join(dir+workspaceArchiveSuffix, "001") {
+		t.Fatalf("expected archive in <folder>.attempts/001, got %s", archive)
+	}
+	if _, err := os.Stat(filepath.Join(archive, "src", "index.js")); err != nil {
+		t.Fatalf("expected previous files in archive: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "src")); !os.IsNotExist(err) {
+		t.Fatalf("expected src/ to be moved out of the workspace, got %v", err)
+	}
+	for _, kept := range []string{"result.json", filepath.Join(attemptsDir, "001.json")} {
+		if _, err := os.Stat(filepath.Join(dir, kept)); err != nil {
+			t.Fatalf("expected %s to stay in place: %v", kept, err)
+		}
+	}
+
+	again, err := archiveEvalWorkspace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != filepath.Join(dir+workspaceArchiveSuffix, "001-2") {
+		t.Fatalf("expected a second archive of the same attempt to get a suffix, got %s", again)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, attemptsDir)); len(entries) != 1 {
+		t.Fatalf("expected attempts/ to hold only the attempt records, got %v", entries)
+	}
+}
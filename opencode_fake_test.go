@@ -0,0 +1,663 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStall ends a fakeScript without going idle; the server keeps sending
+// heartbeats so only the runner's inactivity timeout can end the eval.
+const fakeStall = "<stall>"
+
+// fakeScript is what the fake server replays for one session. Events are
+// event JSON payloads in opencode's schema with {{session}} standing in for
+// the session ID; malformed payloads are sent as-is.
+type fakeScript struct {
+	PromptStatus int
+	Events       []string
+	Messages     []TranscriptMessage
+}
+
+// fakeOpencode is an in-process stand-in for the opencode HTTP+SSE API. Each
+// created session takes the next script; the script is broadcast on /event
+// once its prompt arrives, like opencode does for every subscriber.
+type fakeOpencode struct {
+	server    *httptest.Server
+	heartbeat time.Duration
+
+	mu          sync.Mutex
+	scripts     []fakeScript
+	sessions    map[string]fakeScript
+	prompts     []PromptRequest
+	aborted     []string
+	subscribers map[chan string]struct{}
+}
+
+func newFakeOpencode(scripts ...fakeScript) *fakeOpencode {
+	f := &fakeOpencode{
+		heartbeat:   100 * time.Millisecond,
+		scripts:     scripts,
+		sessions:    make(map[string]fakeScript),
+		subscribers: make(map[chan string]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /session", f.handleCreateSession)
+	mux.HandleFunc("POST /session/{id}/prompt_async", f.handlePrompt)
+	mux.HandleFunc("GET /session/{id}/message", f.handleMessages)
+	mux.HandleFunc("POST /session/{id}/abort", f.handleAbort)
+	mux.HandleFunc("GET /event", f.handleEvents)
+	f.server = httptest.NewServer(mux)
+	return f
+}
+
+// useFakeOpencode points the runner at a fresh fake server for the rest of
+// the test and runs it inside a temporary working directory.
+func useFakeOpencode(t *testing.T, scripts ...fakeScript) *fakeOpencode {
+	t.Helper()
+	f := newFakeOpencode(scripts...)
+	origBackend := agentBackendName
+	agentBackends["fake"] = func() AgentBackend { return &opencodeBackend{attachURL: f.server.URL} }
+	agentBackendName = "fake"
+	t.Cleanup(func() {
+		agentBackendName = origBackend
+		delete(agentBackends, "fake")
+		f.server.CloseClientConnections()
+		f.server.Close()
+	})
+	t.Chdir(t.TempDir())
+	return f
+}
+
+func (f *fakeOpencode) sessionCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.sessions)
+}
+
+func (f *fakeOpencode) receivedPrompts() []PromptRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]PromptRequest(nil), f.prompts...)
+}
+
+func (f *fakeOpencode) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title string `json:"title"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	f.mu.Lock()
+	id := fmt.Sprintf("ses_%d", len(f.sessions)+1)
+	script := fakeScript{Events: []string{fakeIdle()}}
+	if len(f.scripts) > 0 {
+		script, f.scripts = f.scripts[0], f.scripts[1:]
+	}
+	f.sessions[id] = script
+	f.mu.Unlock()
+
+	// Like opencode, the fake serves the directory it runs in.
+	dir, _ := os.Getwd()
+	json.NewEncoder(w).Encode(Session{ID: id, Title: req.Title, Directory: dir})
+}
+
+func (f *fakeOpencode) handlePrompt(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req PromptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	script, ok := f.sessions[id]
+	f.prompts = append(f.prompts, req)
+	f.mu.Unlock()
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	if script.PromptStatus != 0 {
+		http.Error(w, "prompt rejected", script.PromptStatus)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	go f.play(id, script.Events)
+}
+
+func (f *fakeOpencode) handleAbort(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.aborted = append(f.aborted, r.PathValue("id"))
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(true)
+}
+
+func (f *fakeOpencode) abortedSessions() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.aborted...)
+}
+
+func (f *fakeOpencode) handleMessages(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	script := f.sessions[r.PathValue("id")]
+	f.mu.Unlock()
+
+	messages := script.Messages
+	if messages == nil {
+		messages = []TranscriptMessage{}
+	}
+	json.NewEncoder(w).Encode(messages)
+}
+
+func (f *fakeOpencode) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan string, 64)
+	f.mu.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.subscribers, ch)
+		f.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	send := func(data string) {
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	send(`{"type":"server.connected","properties":{}}`)
+
+	heartbeat := time.NewTicker(f.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-ch:
+			send(data)
+		case <-heartbeat.C:
+			send(`{"type":"server.heartbeat","properties":{}}`)
+		}
+	}
+}
+
+func (f *fakeOpencode) play(sessionID string, events []string) {
+	for _, event := range events {
+		if event == fakeStall {
+			return
+		}
+		data := strings.ReplaceAll(event, "{{session}}", sessionID)
+		f.mu.Lock()
+		for ch := range f.subscribers {
+			ch <- data
+		}
+		f.mu.Unlock()
+	}
+}
+
+func fakeEvent(eventType string, properties map[string]interface{}) string {
+	properties["sessionID"] = "{{session}}"
+	data, _ := json.Marshal(Event{Type: eventType, Properties: properties})
+	return string(data)
+}
+
+func fakeIdle() string {
+	return fakeEvent("session.idle", map[string]interface{}{})
+}
+
+func fakeStatus(statusType, message string) string {
+	return fakeEvent("session.status", map[string]interface{}{
+		"status": map[string]interface{}{"type": statusType, "message": message},
+	})
+}
+
+func fakeSessionError(message string) string {
+	return fakeEvent("session.error", map[string]interface{}{
+		"error": map[string]interface{}{"name": "UnknownError", "data": map[string]interface{}{"message": message}},
+	})
+}
+
+func fakeMessageUpdated(messageID string, cost float64, input, output int) string {
+	return fakeEvent("message.updated", map[string]interface{}{
+		"info": map[string]interface{}{
+			"id":        messageID,
+			"sessionID": "{{session}}",
+			"role":      "assistant",
+			"cost":      cost,
+			"tokens":    map[string]interface{}{"input": input, "output": output},
+		},
+	})
+}
+
+func TestRunAgentAgainstFakeOpencode(t *testing.T) {
+	f := useFakeOpencode(t, fakeScript{Events: []string{
+		fakeStatus("busy", ""),
+		`{"type":"message.updated","properties":`,
+		fakeStatus("retry", "rate limited"),
+		fakeMessageUpdated("msg_1", 0.25, 100, 20),
+		fakeIdle(),
+	}})
+
+	result := runAgent(context.Background(), EvalTask{Prompt: "Build <MODEL_NAME>", PromptNumber: 3, Model: "acme/coder-1"}, 0)
+	if !result.Success || result.Error != "" {
+		t.Fatalf("expected success, got %+v", result)
+	}
+	if math.Abs(result.CostUSD-0.25) > 1e-9 || result.Tokens.Input != 100 || result.Tokens.Output != 20 {
+		t.Fatalf("unexpected usage: cost %v tokens %+v", result.CostUSD, result.Tokens)
+	}
+	if result.Port == 0 || agentPorts.reserved[result.Port] {
+		t.Fatalf("expected port %d to be recorded and released", result.Port)
+	}
+
+	prompts := f.receivedPrompts()
+	if len(prompts) != 1 {
+		t.Fatalf("expected 1 prompt, got %d", len(prompts))
+	}
+	if prompts[0].Model.ProviderID != "acme" || prompts[0].Model.ModelID != "coder-1" {
+		t.Fatalf("unexpected model in prompt: %+v", prompts[0].Model)
+	}
+	if got := prompts[0].Parts[0].Text; got != "Build acme/coder-1" {
+		t.Fatalf("prompt text = %q", got)
+	}
+
+	for _, name := range []string{"result.json", "events.jsonl", "transcript.json"} {
+		if _, err := os.Stat(filepath.Join(result.Folder, name)); err != nil {
+			t.Fatalf("expected %s: %v", name, err)
+		}
+	}
+}
+
+func TestRunAgentModelNotFound(t *testing.T) {
+	useFakeOpencode(t, fakeScript{Events: []string{
+		fakeSessionError("Model not found: acme/coder-9. Did you mean: acme/coder-1, acme/coder-2?"),
+	}})
+
+	result := runAgent(context.Background(), EvalTask{Prompt: "hi", PromptNumber: 1, Model: "acme/coder-9"}, 0)
+	if result.Success || result.AgentCompleted {
+		t.Fatalf("expected failure, got %+v", result)
+	}
+	suggestions := modelSuggestions(result.Error)
+	if result.Failure != failureModelNotFound || len(suggestions) != 2 || suggestions[1] != "acme/coder-2" {
+		t.Fatalf("expected model-not-found with suggestions, got %q %q (%v)", result.Failure, result.Error, suggestions)
+	}
+	if result.Failure.transient() {
+		t.Fatalf("model-not-found must not be retried")
+	}
+}
+
+func TestRunAgentPromptRejected(t *testing.T) {
+	useFakeOpencode(t, fakeScript{PromptStatus: http.StatusBadRequest})
+
+	result := runAgent(context.Background(), EvalTask{Prompt: "hi", PromptNumber: 1, Model: "acme/coder-1"}, 0)
+	if result.Success || !strings.Contains(result.Error, "HTTP 400") {
+		t.Fatalf("expected prompt rejection, got %+v", result)
+	}
+}
+
+func TestRunAgentStallTimesOutDespiteHeartbeats(t *testing.T) {
+	useFakeOpencode(t, fakeScript{Events: []string{fakeStatus("busy", ""), fakeStall}})
+
+	start := time.Now()
+	result := runAgent(context.Background(), EvalTask{Prompt: "hi", PromptNumber: 1, Model: "acme/coder-1", InactivityTimeout: time.Second}, 0)
+	if result.Success || result.Failure != failureInactivity || !strings.Contains(result.Error, "no agent activity for 1s") {
+		t.Fatalf("expected inactivity timeout, got %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("timeout took too long: %s", elapsed)
+	}
+}
+
+func TestRunAgentStallTimesOutDespiteOtherSessions(t *testing.T) {
+	f := useFakeOpencode(t, fakeScript{Events: []string{fakeStatus("busy", ""), fakeStall}})
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(100 * time.Millisecond):
+				f.play("other-session", []string{fakeStatus("busy", "")})
+			}
+		}
+	}()
+
+	// Without the timeout the eval would never end; the deadline turns that
+	// into an interrupted result.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result := runAgent(ctx, EvalTask{Prompt: "hi", PromptNumber: 1, Model: "acme/coder-1", InactivityTimeout: time.Second}, 0)
+	if result.Success || result.Failure != failureInactivity {
+		t.Fatalf("expected inactivity timeout despite other sessions' events, got %+v", result)
+	}
+}
+
+func TestRunAgentInterruptedRecordsResult(t *testing.T) {
+	origRetries := transientRetries
+	transientRetries = 1
+	t.Cleanup(func() { transientRetries = origRetries })
+	f := useFakeOpencode(t, fakeScript{Events: []string{fakeStatus("busy", ""), fakeStall}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+	result := runAgentWithRetry(ctx, EvalTask{Prompt: "hi", PromptNumber: 1, Model: "acme/coder-1"}, 0)
+	if result.Success || result.Failure != failureInterrupted || result.Error != "interrupted" {
+		t.Fatalf("expected interrupted result, got %+v", result)
+	}
+	if got := f.sessionCount(); got != 1 {
+		t.Fatalf("expected an interrupted eval not to be retried, got %d sessions", got)
+	}
+
+	data, err := os.ReadFile(filepath.Join(result.Folder, "result.json"))
+	if err != nil {
+		t.Fatalf("expected result.json for the interrupted eval: %v", err)
+	}
+	var rf EvalResultFile
+	if err := json.Unmarshal(data, &rf); err != nil {
+		t.Fatal(err)
+	}
+	if rf.Failure != failureInterrupted || rf.Success {
+		t.Fatalf("expected result.json to record the interruption, got %+v", rf)
+	}
+}
+
+func TestRunAgentInterruptedWhileGrading(t *testing.T) {
+	useFakeOpencode(t)
+	marker := filepath.Join(t.TempDir(), "grading")
+	grader := &Grader{Command: "touch " + marker + "; sleep 30"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			if _, err := os.Stat(marker); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	result := runAgent(ctx, EvalTask{Prompt: "hi", PromptNumber: 1, Model: "acme/coder-1", Grader: grader}, 0)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected grading to stop promptly, took %s", elapsed)
+	}
+	if result.Success || result.Failure != failureInterrupted || result.Error != "interrupted while grading" || result.GradedPass != nil {
+		t.Fatalf("expected the eval to be recorded as interrupted, got %+v", result)
+	}
+}
+
+func TestWaitForServerRejectsSessionsOfAnotherServer(t *testing.T) {
+	f := useFakeOpencode(t)
+	// A backend that started its own server for dir, while the fake holds
+	// the port and serves the working directory.
+	backend := &opencodeBackend{client: &http.Client{Timeout: time.Second}, baseURL: f.server.URL, cmd: &exec.Cmd{}}
+
+	backend.dir = t.TempDir()
+	start := time.Now()
+	session, portLost, err := waitForServer(context.Background(), backend, 0)
+	if session != nil || !portLost || !errors.Is(err, errForeignServer) {
+		t.Fatalf("expected the foreign session to be rejected, got %+v %v %v", session, portLost, err)
+	}
+	if elapsed := time.Since(start); elapsed >= serverReadyTimeout {
+		t.Fatalf("expected the foreign server to be noticed before the readiness timeout, took %s", elapsed)
+	}
+
+	backend.dir = "."
+	if session, portLost, err = waitForServer(context.Background(), backend, 0); session == nil || portLost || err != nil {
+		t.Fatalf("expected a session from the eval folder's server, got %+v %v %v", session, portLost, err)
+	}
+}
+
+func TestRunAllEvalsSequentialStopsSchedulingWhenInterrupted(t *testing.T) {
+	f := useFakeOpencode(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tasks := []EvalTask{
+		{Prompt: "one", PromptNumber: 1, Model: "acme/coder-1"},
+		{Prompt: "two", PromptNumber: 2, Model: "acme/coder-1"},
+	}
+	results := runAllEvalsSequential(ctx, tasks)
+	for i, r := range results {
+		if r.Failure != failureInterrupted || r.Folder != "" || r.PromptNumber != tasks[i].PromptNumber {
+			t.Fatalf("expected task %d to be skipped, got %+v", i, r)
+		}
+	}
+	if got := f.sessionCount(); got != 0 {
+		t.Fatalf("expected no sessions after interruption, got %d", got)
+	}
+}
+
+func TestRunAllEvalsSequentialContinuesAfterModelNotFoundWithoutPrompt(t *testing.T) {
+	f := useFakeOpencode(t,
+		fakeScript{Events: []string{fakeSessionError("Model not found: acme/coder-9. Did you mean: acme/coder-1?")}},
+		fakeScript{Events: []string{fakeIdle()}},
+	)
+
+	tasks := []EvalTask{
+		{Prompt: "one", PromptNumber: 1, Model: "acme/coder-9"},
+		{Prompt: "two", PromptNumber: 2, Model: "acme/coder-1"},
+	}
+	results := runAllEvalsSequential(context.Background(), tasks)
+	if results[0].Failure != failureModelNotFound || results[0].Attempts == 0 {
+		t.Fatalf("expected task 0 to record model_not_found, got %+v", results[0])
+	}
+	if !results[1].Success {
+		t.Fatalf("expected task 1 to run after the missing model, got %+v", results[1])
+	}
+	if got := f.sessionCount(); got != 2 {
+		t.Fatalf("expected 2 sessions, got %d", got)
+	}
+}
+
+func TestRunAgentAbortsOverCostBudget(t *testing.T) {
+	origCost := maxEvalCost
+	maxEvalCost = 0.5
+	t.Cleanup(func() { maxEvalCost = origCost })
+	f := useFakeOpencode(t, fakeScript{Events: []string{
+		fakeMessageUpdated("msg_1", 0.25, 100, 20),
+		fakeMessageUpdated("msg_2", 0.30, 100, 20),
+		fakeStall,
+	}})
+
+	result := runAgent(context.Background(), EvalTask{Prompt: "hi", PromptNumber: 1, Model: "acme/coder-1"}, 0)
+	if result.Success || result.Failure != failureBudget || !strings.Contains(result.Error, "--max-cost") {
+		t.Fatalf("expected cost budget failure, got %+v", result)
+	}
+	if got := f.abortedSessions(); len(got) != 1 || got[0] != "ses_1" {
+		t.Fatalf("expected the session to be aborted, got %v", got)
+	}
+}
+
+func TestRunAgentAbortsOverDurationBudget(t *testing.T) {
+	origDuration := maxEvalDuration
+	maxEvalDuration = time.Second
+	t.Cleanup(func() { maxEvalDuration = origDuration })
+	f := useFakeOpencode(t, fakeScript{Events: []string{fakeStatus("busy", ""), fakeStall}})
+
+	result := runAgentWithRetry(context.Background(), EvalTask{Prompt: "hi", PromptNumber: 1, Model: "acme/coder-1"}, 0)
+	if result.Success || result.Failure != failureBudget || !strings.Contains(result.Error, "--max-duration") {
+		t.Fatalf("expected duration budget failure, got %+v", result)
+	}
+	if got := f.sessionCount(); got != 1 {
+		t.Fatalf("expected a budget failure not to be retried, got %d sessions", got)
+	}
+	if got := f.abortedSessions(); len(got) != 1 {
+		t.Fatalf("expected the session to be aborted, got %v", got)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(result.Folder, "result.json"))
+	var rf EvalResultFile
+	if err := json.Unmarshal(data, &rf); err != nil || rf.Failure != failureBudget {
+		t.Fatalf("expected failure in result.json, got %+v (%v)", rf, err)
+	}
+}
+
+func TestRunAgentWithRetryRecoversFromStall(t *testing.T) {
+	origRetries := transientRetries
+	transientRetries = 1
+	t.Cleanup(func() { transientRetries = origRetries })
+
+	f := useFakeOpencode(t,
+		fakeScript{Events: []string{fakeStall}},
+		fakeScript{Events: []string{fakeIdle()}},
+	)
+
+	result := runAgentWithRetry(context.Background(), EvalTask{Prompt: "hi", PromptNumber: 1, Model: "acme/coder-1", InactivityTimeout: time.Second}, 0)
+	if !result.Success {
+		t.Fatalf("expected retry to succeed, got %+v", result)
+	}
+	if result.Attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", result.Attempts)
+	}
+	if got := f.sessionCount(); got != 2 {
+		t.Fatalf("expected 2 sessions, got %d", got)
+	}
+}
+
+func TestRunCommandWithSuiteWritesManifest(t *testing.T) {
+	f := useFakeOpencode(t)
+	origArgs, origTimeout, origRetries, origOutput, origVars := os.Args, inactivityTimeout, transientRetries, outputMode, promptVars
+	t.Cleanup(func() {
+		os.Args, inactivityTimeout, transientRetries, outputMode, promptVars = origArgs, origTimeout, origRetries, origOutput, origVars
+	})
+
+	prompts := `{"version": 2, "next_id": 3, "prompts": [{"id": 1, "text": "one"}, {"id": 2, "text": "Open <PAGE>"}]}`
+	suite := `{"name": "nightly", "version": 4, "prompts": [2], "models": ["acme/coder-1"], "backend": "fake", "vars": {"PAGE": "home"}}`
+	if err := os.WriteFile(promptsFile, []byte(prompts), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("nightly.json", []byte(suite), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Args = []string{"high-evals", "run", "--suite", "nightly.json", "--output", "json"}
+	if code := runCommand(); code != exitOK {
+		t.Fatalf("runCommand exit code = %d", code)
+	}
+
+	folders, err := scanEvalFolders()
+	if err != nil || len(folders) != 1 || folders[0].Result == nil {
+		t.Fatalf("expected one finished eval, got %+v (%v)", folders, err)
+	}
+	rf := folders[0].Result
+	if rf.PromptNumber != 2 || rf.Suite == nil || rf.Suite.Name != "nightly" || rf.Suite.Version != 4 || !strings.HasPrefix(rf.Suite.Hash, "sha256:") {
+		t.Fatalf("expected suite stamp in result.json, got %+v", rf)
+	}
+	if sent := f.receivedPrompts(); len(sent) != 1 || sent[0].Parts[0].Text != "Open home" {
+		t.Fatalf("expected suite vars to render the prompt, got %+v", sent)
+	}
+
+	data, err := os.ReadFile(filepath.Join(runsDir, rf.RunID, "manifest.json"))
+	if rf.RunID == "" || err != nil {
+		t.Fatalf("expected a run manifest for run %q: %v", rf.RunID, err)
+	}
+	var manifest RunManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("parsing manifest: %v", err)
+	}
+	if manifest.Command != "run" || manifest.FinishedAt == "" || manifest.Flags["suite"] != "nightly.json" || manifest.Suite == nil {
+		t.Fatalf("unexpected manifest header: %+v", manifest)
+	}
+	if len(manifest.Tasks) != 1 || manifest.Tasks[0].Folder != folders[0].Path || manifest.Tasks[0].Success == nil || !*manifest.Tasks[0].Success {
+		t.Fatalf("unexpected manifest tasks: %+v", manifest.Tasks)
+	}
+	if manifest.Summary == nil || manifest.Summary.Total != 1 || manifest.Summary.Passed != 1 || manifest.Summary.ExitCode != exitOK {
+		t.Fatalf("unexpected manifest summary: %+v", manifest.Summary)
+	}
+}
+
+func TestResumeCommandFailedFilter(t *testing.T) {
+	f := useFakeOpencode(t)
+	origArgs, origOutput := os.Args, outputMode
+	t.Cleanup(func() { os.Args, outputMode = origArgs, origOutput })
+
+	evals := map[string]string{
+		"evals/2026-10-01_10-00-00_p1_0_acme-coder-1": `{"model": "acme/coder-1", "success": false, "prompt_number": 1}`,
+		"evals/2026-10-01_10-00-00_p2_1_acme-coder-1": `{"model": "acme/coder-1", "success": true, "prompt_number": 2}`,
+	}
+	for folder, result := range evals {
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(folder, "prompt.txt"), []byte("prompt "+filepath.Base(folder)), 0644)
+		os.WriteFile(filepath.Join(folder, "result.json"), []byte(result), 0644)
+	}
+
+	os.Args = []string{"high-evals", "resume", "--failed", "--backend", "fake", "--output", "json"}
+	if code := resumeCommand(); code != exitOK {
+		t.Fatalf("resumeCommand exit code = %d", code)
+	}
+
+	if got := f.sessionCount(); got != 1 {
+		t.Fatalf("expected only the failed eval to be resumed, got %d sessions", got)
+	}
+	folders, _ := scanEvalFolders()
+	for _, ef := range folders {
+		if ef.Result == nil || !ef.Result.Success {
+			t.Fatalf("expected every eval to pass after resume, got %s: %+v", ef.Path, ef.Result)
+		}
+	}
+}
+
+func TestResumeCommandKeepsPerEvalModels(t *testing.T) {
+	f := useFakeOpencode(t)
+	origArgs, origOutput := os.Args, outputMode
+	t.Cleanup(func() { os.Args, outputMode = origArgs, origOutput })
+
+	evals := map[string]string{
+		"evals/2026-10-01_10-00-00_p1_0_acme-coder-1":  `{"model": "acme/coder-1", "success": false}`,
+		"evals/2026-10-01_10-00-00_p1_1_other-model-2": `{"model": "other/model-2", "success": false}`,
+	}
+	for folder, result := range evals {
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(folder, "prompt.txt"), []byte("prompt"), 0644)
+		os.WriteFile(filepath.Join(folder, "result.json"), []byte(result), 0644)
+	}
+
+	os.Args = []string{"high-evals", "resume", "--failed", "--backend", "fake", "--output", "json"}
+	if code := resumeCommand(); code != exitOK {
+		t.Fatalf("resumeCommand exit code = %d", code)
+	}
+
+	var sent []string
+	for _, p := range f.receivedPrompts() {
+		sent = append(sent, p.Model.ProviderID+"/"+p.Model.ModelID)
+	}
+	sort.Strings(sent)
+	if strings.Join(sent, ",") != "acme/coder-1,other/model-2" {
+		t.Fatalf("expected each folder to keep its model, got %v", sent)
+	}
+
+	folders, _ := scanEvalFolders()
+	for _, ef := range folders {
+		if want := evals[ef.Path]; !strings.Contains(want, ef.Result.Model) {
+			t.Fatalf("%s: result.json model changed to %s", ef.Path, ef.Result.Model)
+		}
+	}
+}
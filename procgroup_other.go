@@ -0,0 +1,27 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Without process groups only the server process itself is signalled.
+func setNewProcessGroup(cmd *exec.Cmd) {}
+
+func signalProcessGroup(pid int, sig os.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if sig == os.Kill {
+		return p.Kill()
+	}
+	return p.Signal(sig)
+}
+
+func processGroupAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
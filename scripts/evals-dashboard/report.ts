@@ -114,17 +114,31 @@ async function loadPromptNumberLookup(promptsPath: string): Promise<Map<string,
     return lookup;
   }
 
-  if (!Array.isArray(prompts)) {
+  if (Array.isArray(prompts)) {
+    for (let i = 0; i < prompts.length; i += 1) {
+      const prompt = prompts[i];
+      if (typeof prompt !== "string") {
+        continue;
+      }
+      if (!lookup.has(prompt)) {
+        lookup.set(prompt, i + 1);
+      }
+    }
+    return lookup;
+  }
+
+  const records = (prompts as { prompts?: unknown } | null)?.prompts;
+  if (!Array.isArray(records)) {
     return lookup;
   }
 
-  for (let i = 0; i < prompts.length; i += 1) {
-    const prompt = prompts[i];
-    if (typeof prompt !== "string") {
+  for (const record of records) {
+    const { id, text } = (record ?? {}) as { id?: unknown; text?: unknown };
+    if (typeof id !== "number" || typeof text !== "string") {
       continue;
     }
-    if (!lookup.has(prompt)) {
-      lookup.set(prompt, i + 1);
+    if (!lookup.has(text)) {
+      lookup.set(text, id);
     }
   }
 